@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+var errObjectMissing = errors.New("object does not exist")
+
+// catFile is a long-lived `git cat-file --batch` process, used to read many objects without forking
+// a new git process for each one.
+type catFile struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+}
+
+func newCatFile(dir string) (*catFile, error) {
+	cmd := exec.Command("git", "cat-file", "--batch")
+	cmd.Dir = dir
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("start git cat-file: %w", err)
+	}
+
+	return &catFile{cmd: cmd, stdin: stdin, stdout: bufio.NewReader(stdout)}, nil
+}
+
+// object returns the type and contents of the object named by rev
+func (c *catFile) object(rev string) (string, []byte, error) {
+	if strings.ContainsAny(rev, "\r\n") {
+		return "", nil, fmt.Errorf("invalid object name %q", rev)
+	}
+
+	if _, err := fmt.Fprintln(c.stdin, rev); err != nil {
+		return "", nil, fmt.Errorf("write to git cat-file: %w", err)
+	}
+
+	// The header is either "<sha> <type> <size>", or "<rev> missing" (or ambiguous)
+	header, err := c.stdout.ReadString('\n')
+	if err != nil {
+		return "", nil, fmt.Errorf("read from git cat-file: %w", err)
+	}
+
+	fields := strings.Fields(header)
+	if len(fields) != 3 {
+		return "", nil, fmt.Errorf("%s: %w", rev, errObjectMissing)
+	}
+
+	size, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return "", nil, fmt.Errorf("unexpected git cat-file header %q", header)
+	}
+
+	// The contents are followed by a newline
+	data := make([]byte, size+1)
+	if _, err := io.ReadFull(c.stdout, data); err != nil {
+		return "", nil, fmt.Errorf("read %s from git cat-file: %w", rev, err)
+	}
+
+	return fields[1], data[:size], nil
+}
+
+// read returns the contents of the object named by rev, which must be of type typ
+func (c *catFile) read(rev, typ string) ([]byte, error) {
+	t, data, err := c.object(rev)
+	if err != nil {
+		return nil, err
+	} else if t != typ {
+		return nil, fmt.Errorf("%s is a %s, not a %s", rev, t, typ)
+	}
+	return data, nil
+}
+
+// Close stops the git process
+func (c *catFile) Close() error {
+	c.stdin.Close()
+	return c.cmd.Wait()
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"slices"
 	"strings"
 )
 
@@ -10,6 +11,9 @@ type Change struct {
 	hash   string
 	author string
 
+	// parent is the first parent of the local commit, used to order changes
+	parent string
+
 	message string
 
 	// trailers are lines to add to the end of the body stored as a list to maintain insertion order
@@ -18,6 +22,63 @@ type Change struct {
 	// entries is a map of path -> content for files modified in the change
 	// empty or nil content indicates a deleted file
 	entries map[string][]byte
+
+	// modes is a map of path -> git file mode for entries that are not regular files, such as
+	// executables, symlinks and submodules. Paths not present are regular files. The content of a
+	// symlink is its target, and the content of a submodule is the hash of the commit it points at.
+	modes map[string]string
+
+	// mirrors are directories whose remote files are deleted if they are not in entries, see
+	// [expandMirrors]
+	mirrors []mirrorDir
+}
+
+// git file modes
+const (
+	modeRegular    = "100644"
+	modeExecutable = "100755"
+	modeSymlink    = "120000"
+	modeSubmodule  = "160000"
+)
+
+// mode returns the git file mode of path
+func (c Change) mode(path string) string {
+	if m, ok := c.modes[path]; ok {
+		return m
+	}
+	return modeRegular
+}
+
+// hasSpecialModes reports whether any entry in the change is not a regular file
+func (c Change) hasSpecialModes() bool {
+	for p, content := range c.entries {
+		if content != nil && c.mode(p) != modeRegular {
+			return true
+		}
+	}
+	return false
+}
+
+// hasDeletions reports whether the change deletes any files
+func (c Change) hasDeletions() bool {
+	for _, content := range c.entries {
+		if content == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// submodules returns the sorted paths of submodules added or updated by the change
+func (c Change) submodules() []string {
+	paths := []string{}
+	for p, content := range c.entries {
+		if content != nil && c.mode(p) == modeSubmodule {
+			paths = append(paths, p)
+		}
+	}
+	slices.Sort(paths)
+	return paths
 }
 
 // Splits a commit message on the first blank line
@@ -32,30 +93,21 @@ func (c Change) Headline() string {
 	return h
 }
 
-// Body is everything after the headline, including trailers
+// Body is everything after the headline, including trailers. The author and trailers are merged
+// into the trailer block at the end of the message, as git interpret-trailers would.
 func (c Change) Body() string {
 	_, b := c.splitMessage()
-	b = strings.TrimSpace(b)
-
-	sb := &strings.Builder{}
-	sb.WriteString(b)
-	sb.WriteString("\n\n")
-
-	// maybe write trailers, if the trailer doesn't already exist in the body
-	// this is a naive implementation, but it mostly does the job
-	lowerbody := strings.ToLower(b)
+	text, block := splitTrailers(b)
 
+	trailers := c.trailers
 	if c.author != "" {
-		authorline := fmt.Sprintf("Co-authored-by: %s", c.author)
-		c.trailers = append([]string{authorline}, c.trailers...)
+		trailers = append([]string{fmt.Sprintf("Co-authored-by: %s", c.author)}, trailers...)
 	}
 
-	for _, t := range c.trailers {
-		if !strings.Contains(lowerbody, strings.ToLower(t)) {
-			sb.WriteString(t)
-			sb.WriteString("\n")
-		}
+	block = mergeTrailers(block, trailers)
+	if len(block) == 0 {
+		return text
 	}
 
-	return strings.TrimSpace(sb.String())
+	return strings.TrimSpace(text + "\n\n" + strings.Join(block, "\n"))
 }
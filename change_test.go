@@ -14,8 +14,18 @@ func TestChangeBody(t *testing.T) {
 		headline string
 		body     string
 	}{{
+		// existing co-author trailers are deduplicated, and their casing normalized for GitHub
 		"subject\n\nbody\n\nco-authored-by: author", "author", nil,
-		"subject", "body\n\nco-authored-by: author",
+		"subject", "body\n\nCo-authored-by: author",
+	}, {
+		// a mention in prose is not a trailer
+		"subject\n\nmentions Co-authored-by: author in text", "author", nil,
+		"subject", "mentions Co-authored-by: author in text\n\nCo-authored-by: author",
+	}, {
+		// new trailers are merged into the existing trailer block
+		"subject\n\nbody\n\nSigned-off-by: someone\nFoo: bar", "author",
+		[]string{"foo: BAR", "Foo: baz"},
+		"subject", "body\n\nSigned-off-by: someone\nFoo: bar\nCo-authored-by: author\nFoo: baz",
 	}, {
 		"subject only", "", nil,
 		"subject only", "",
@@ -1,12 +1,16 @@
 package main
 
 import (
+	"cmp"
 	"context"
 	"errors"
 	"fmt"
-	"io"
 	"io/fs"
+	"maps"
 	"os"
+	"path"
+	"path/filepath"
+	"slices"
 	"strings"
 )
 
@@ -15,8 +19,15 @@ type CommitCmd struct {
 
 	Author  string   `help:"Specify an author using the standard 'A U Thor <author@example.com>' format."`
 	Message []string `short:"m" help:"Specify a commit message. If used multiple times, values are concatenated as separate paragraphs."`
-	Force   bool     `help:"Force commiting empty files. Only useful if you know you're deleting a file."`
-	Files   []string `arg:"" help:"Files to commit."`
+	Force   bool     `help:"Force commiting empty files. Only useful if you know you're deleting a file. With directory arguments, also delete remote files in the directory that don't exist locally."`
+	Exclude []string `name:"exclude" help:"Leave out files matching this pattern, which may be repeated. Patterns without a slash match file and directory names anywhere."`
+
+	RepoPath string `name:"repo-path" default:"." help:"Directory that files are read from and relative to, and where git is run with --staged or --all-changes. Defaults to the current directory."`
+
+	Staged     bool `name:"staged" help:"Commit the changes staged in the git index, including deletions, instead of a list of files."`
+	AllChanges bool `name:"all-changes" help:"Commit every change reported by git status, including untracked files that aren't ignored, instead of a list of files."`
+
+	Files []string `arg:"" optional:"" help:"Files, directories or glob patterns to commit."`
 }
 
 func (c *CommitCmd) Help() string {
@@ -27,13 +38,38 @@ It is expected that the paths on disk match to paths on the remote. That is, if
 "path/to/file.txt" then the contents of that file on disk will be applied to that same file on the
 remote when the commit is created.
 
+Paths are relative to --repo-path, which defaults to the current directory. To place files
+somewhere else on the remote, --prefix adds a directory to the start of every path, and
+--strip-prefix removes one. For example, to commit files from build/api to docs/api on the remote:
+
+	commit-headless commit [flags...] --repo-path build --prefix docs -- api
+
+Directories are committed recursively, and arguments containing glob characters that don't name an
+existing file are matched against every file, where "**" matches any number of directories (for
+example, 'dist/**/*.js'). Quote patterns so that the shell doesn't expand them. Files matching any
+--exclude pattern are left out.
+
 You can also use this to delete files by passing a path to a file that does not exist on disk. Note
 that for safety reasons, commit-headless will require an extra flag --force before accepting
-deletions. It is an error to attempt to delete a file that does not exist.
+deletions. It is an error to attempt to delete a file that does not exist on the remote, which is
+checked before anything is pushed. Use --ignore-missing-deletions to leave such deletions out
+instead.
 
 If you pass a path to a file that does not exist on disk without the --force flag, commit-headless
 will print an error and exit.
 
+With --force, a directory argument is mirrored: files in that directory on the remote that don't
+exist locally are deleted, unless they match an --exclude pattern.
+
+Files that .gitattributes stores in Git LFS are refused, since they would be committed as regular
+files rather than through LFS. Attribute files are read from the top of the worktree containing
+--repo-path down to each file. Use --lfs=ignore to commit such files anyway.
+
+Instead of listing files, --staged commits exactly what is staged in the git index, reading the
+contents from the index, and --all-changes commits every change reported by "git status" (staged or
+not, including untracked files that aren't ignored), reading the contents from disk. Paths are
+relative to the root of the repository, and deletions reported by git don't need --force.
+
 You can supply a commit message via --message/-m and an author via --author/-a. If unspecified,
 default values will be used.
 
@@ -46,12 +82,38 @@ Examples:
 	echo "hello" > hi-there.txt
 	commit-headless commit [flags...] --force -- hi-there.txt file/i/do/not/want
 
+	# Mirror a generated directory, deleting remote files that are no longer generated
+	commit-headless commit [flags...] --force --exclude '*.map' -- dist
+
 	# Commit a change with a custom message
 	commit-headless commit [flags...] -m"ran a pipeline" -- output.txt
+
+	# Commit whatever a formatter changed
+	gofmt -w .
+	commit-headless commit [flags...] --all-changes -m"gofmt"
 	`
 }
 
 func (c *CommitCmd) Run() error {
+	change, err := c.change()
+	if err != nil {
+		result := newPushResult(c.remoteFlags)
+		return result.fail(outwriter, c.Output, err)
+	}
+
+	return pushChanges(context.Background(), c.remoteFlags, change)
+}
+
+// change reads the files to commit
+func (c *CommitCmd) change() (Change, error) {
+	if c.Staged && c.AllChanges {
+		return Change{}, errors.New("cannot use --staged together with --all-changes")
+	}
+
+	if (c.Staged || c.AllChanges) && len(c.Files) != 0 {
+		return Change{}, errors.New("cannot use --staged or --all-changes together with a list of files")
+	}
+
 	change := Change{
 		hash:    strings.Repeat("0", 40),
 		author:  c.Author,
@@ -59,32 +121,163 @@ func (c *CommitCmd) Run() error {
 		entries: map[string][]byte{},
 	}
 
-	rootfs := os.DirFS(".")
+	root := cmp.Or(c.RepoPath, ".")
+
+	switch {
+	case c.Staged || c.AllChanges:
+		repo := &Repository{path: root}
+		defer repo.Close()
+
+		var err error
+		if c.Staged {
+			change.entries, change.modes, err = repo.stagedChanges()
+		} else {
+			change.entries, change.modes, err = repo.worktreeChanges()
+		}
+		if err != nil {
+			return Change{}, err
+		}
+
+		if len(change.entries) == 0 {
+			return Change{}, errors.New("no changes found to commit")
+		}
+
+		// Paths from git are relative to the root of the worktree
+		root, err = repo.toplevel()
+		if err != nil {
+			return Change{}, err
+		}
+	case len(c.Files) == 0:
+		return Change{}, errors.New("no files to commit, pass a list of files or use --staged or --all-changes")
+	default:
+		var err error
+		change.entries, change.mirrors, err = c.readFiles(os.DirFS(root))
+		if err != nil {
+			return Change{}, err
+		}
+	}
+
+	// Files on disk that are stored in LFS hold their real content rather than a pointer, so the
+	// attributes are needed to recognise them
+	if c.LFS != lfsIgnore {
+		attrs, err := worktreeAttributes(root, slices.Collect(maps.Keys(change.entries)))
+		if err != nil {
+			return Change{}, err
+		}
+
+		if err := checkLFS(c.LFS, attrs, []Change{change}); err != nil {
+			return Change{}, err
+		}
+	}
+
+	return change, nil
+}
+
+// readFiles returns the contents of the files named by c.Files from rootfs, expanding directories
+// and glob patterns, with nil content for files that don't exist, which are only allowed with
+// --force. With --force, directories are also returned as mirrors.
+func (c *CommitCmd) readFiles(rootfs fs.FS) (map[string][]byte, []mirrorDir, error) {
+	entries := map[string][]byte{}
+	mirrors := []mirrorDir{}
+
+	add := func(p string) error {
+		if excluded(c.Exclude, p) {
+			return nil
+		}
+
+		contents, err := fs.ReadFile(rootfs, p)
+		if err != nil {
+			return fmt.Errorf("read %q: %w", p, err)
+		}
+
+		entries[p] = contents
+		return nil
+	}
+
+	for _, arg := range c.Files {
+		arg = path.Clean(strings.TrimPrefix(arg, "./"))
 
-	for _, path := range c.Files {
-		path = strings.TrimPrefix(path, "./")
+		// An existing path is used as is, even if its name contains glob characters, such as
+		// app/[slug]/page.tsx
+		fi, err := fs.Stat(rootfs, arg)
+		if errors.Is(err, fs.ErrNotExist) && hasMeta(arg) {
+			matches, err := globFiles(rootfs, arg)
+			if err != nil {
+				return nil, nil, fmt.Errorf("match %q: %w", arg, err)
+			} else if len(matches) == 0 && !c.Force {
+				return nil, nil, fmt.Errorf("pattern %q does not match any files", arg)
+			}
+
+			for _, p := range matches {
+				if err := add(p); err != nil {
+					return nil, nil, err
+				}
+			}
+
+			// With --force, a pattern matching nothing is a file to delete whose name contains
+			// glob characters
+			if len(matches) > 0 {
+				continue
+			}
+		}
 
-		fp, err := rootfs.Open(path)
 		if errors.Is(err, fs.ErrNotExist) {
 			if !c.Force {
-				return fmt.Errorf("file %q does not exist, but --force was not set", path)
+				return nil, nil, fmt.Errorf("file %q does not exist, but --force was not set", arg)
 			}
 
-			change.entries[path] = nil
+			if !excluded(c.Exclude, arg) {
+				entries[arg] = nil
+			}
 			continue
 		} else if err != nil {
-			return fmt.Errorf("could not open file %q: %w", path, err)
+			return nil, nil, fmt.Errorf("could not open file %q: %w", arg, err)
 		}
 
-		contents, err := io.ReadAll(fp)
+		if !fi.IsDir() {
+			if err := add(arg); err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+
+		files, err := walkFiles(rootfs, arg)
 		if err != nil {
-			return fmt.Errorf("read %q: %w", path, err)
+			return nil, nil, fmt.Errorf("read directory %q: %w", arg, err)
 		}
 
-		change.entries[path] = contents
+		for _, p := range files {
+			if err := add(p); err != nil {
+				return nil, nil, err
+			}
+		}
+
+		if c.Force {
+			mirrors = append(mirrors, mirrorDir{dir: arg, exclude: c.Exclude})
+		}
 	}
 
-	owner, repository := c.Target.Owner(), c.Target.Repository()
+	return entries, mirrors, nil
+}
+
+// worktreeAttributes returns the .gitattributes files on disk that apply to paths, which are
+// relative to root. If root is inside a git worktree, attribute files from the top of the worktree
+// down are read, otherwise only those in root and below.
+func worktreeAttributes(root string, paths []string) (gitAttributes, error) {
+	top, base := root, "."
+
+	// Not being in a worktree, or not having git installed, only means there are fewer files to read
+	if toplevel, err := (&Repository{path: root}).toplevel(); err == nil {
+		// The top of the worktree has its symlinks resolved, so root needs them resolved as well
+		if abs, err := filepath.Abs(root); err == nil {
+			if abs, err = filepath.EvalSymlinks(abs); err == nil {
+				if rel, err := filepath.Rel(toplevel, abs); err == nil && filepath.IsLocal(rel) {
+					top, base = toplevel, filepath.ToSlash(rel)
+				}
+			}
+		}
+	}
 
-	return pushChanges(context.Background(), owner, repository, c.Branch, c.HeadSha, c.CreateBranch, c.DryRun, change)
+	fsys := os.DirFS(top)
+	return readAttributes(func(p string) ([]byte, error) { return fs.ReadFile(fsys, p) }, base, paths)
 }
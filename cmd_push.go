@@ -2,21 +2,29 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"maps"
 	"os"
+	"slices"
 )
 
 type PushCmd struct {
 	remoteFlags
-	RepoPath string   `name:"repo-path" default:"." help:"Path to the repository that contains the commits. Defaults to the current directory."`
-	Commits  []string `arg:"" optional:"" help:"Commit hashes to be applied to the target. Defaults to reading a list of commit hashes from standard input."`
+	RepoPath      string   `name:"repo-path" default:"." help:"Path to the repository that contains the commits. Defaults to the current directory."`
+	FlattenMerges bool     `name:"flatten-merges" help:"Push merge commits as regular commits containing their changes relative to the first parent."`
+	GitReader     string   `name:"git-reader" enum:"auto,exec,native" default:"auto" help:"How to read the local repository. One of 'exec' (run git), 'native' (read the repository files directly) or 'auto' (exec when git is installed)."`
+	OriginalHash  bool     `name:"original-commit-trailer" help:"Add an 'Original-commit: <sha>' trailer with the hash of the local commit to every pushed commit."`
+	Since         string   `name:"since" help:"Push every commit after this reference up to HEAD. Equivalent to passing <since>..HEAD."`
+	Commits       []string `arg:"" optional:"" help:"Commits to be applied to the target, as hashes, references or revision ranges (such as origin/main..HEAD). Defaults to reading a list of commit hashes from standard input."`
 }
 
 func (c *PushCmd) Help() string {
 	return `
 This command should be run when you have commits created locally that you'd like to push to the
-remote. You can pass the commit hashes either as space-separated arguments or over standard input
-with one commit hash per line.
+remote. You can pass the commits either as space-separated arguments or over standard input with
+one commit hash per line. Arguments may be commit hashes, references such as HEAD or tag names, or
+revision ranges such as origin/main..HEAD.
 
 You must provide a GitHub token via the environment in one of the following variables, in preference
 order:
@@ -25,20 +33,62 @@ order:
 	- GITHUB_TOKEN
 	- GH_TOKEN
 
+Alternatively, commit-headless can authenticate as a GitHub App installation by supplying
+--app-id, --app-installation-id and --app-private-key. Installation tokens are minted on demand and
+refreshed when they expire.
+
 On a successful push, the hash of the last commit pushed will be printed to standard output,
-allowing you to capture it in a script. All other output is printed to standard error.
+allowing you to capture it in a script. All other output is printed to standard error. With
+--output=json, a JSON document describing every pushed commit is printed instead.
 
 For example, to push the most recent three commits:
 
-	commit-headless push -T owner/repo --branch branch HEAD HEAD^ HEAD^^
+	commit-headless push -T owner/repo --branch branch HEAD~3..HEAD
 
 Or, to push all commits on the current branch that aren't on the main branch:
 
+	commit-headless push -T owner/repo --branch branch --since main
+
+Or, using git log:
+
 	git log --oneline main.. | commit-headless push -T owner/repo --branch branch
 
 When reading commit hashes from standard input, the only requirement is that the commit hash is at
 the start of the line, and any other content is separated by at least one whitespace character.
 
+Files are pushed to the same paths they have in the local repository. If the layouts differ,
+--strip-prefix removes a directory from the start of every path, and --prefix adds one. Changes to
+files outside of the stripped directory are an error. For example, to push the commits of a
+repository that holds the contents of docs/ on the remote:
+
+	commit-headless push -T owner/repo --branch branch --prefix docs --since main
+
+Merge commits are refused by default. With --flatten-merges, a merge commit is pushed as a regular
+commit containing its changes relative to its first parent. The merge message is kept, and the
+other parents are recorded in "Merge-parent" trailers.
+
+Use --trailer to add trailers to every commit message, and --original-commit-trailer to record the
+local commit each remote commit was created from. --message-template rewrites every message with a
+Go template, for example to prefix each headline:
+
+	commit-headless push -T owner/repo --branch branch --message-template '[sync] {{.Message}}' --since main
+
+Files stored in Git LFS are committed as pointer files, and pushing them doesn't upload the objects
+they point to, so commits containing LFS pointers are refused. If the objects have already been
+uploaded, such as with "git lfs push", use --lfs=pointers to push the pointers anyway. Files that
+the commit's .gitattributes stores in LFS but which hold regular content are refused as well,
+unless --lfs=ignore is set, which skips every check.
+
+By default the repository is read by running git, or directly if git is not installed (such as in
+the commit-headless container image). Use --git-reader to choose explicitly. Reading directly
+supports commit hashes, references, the ~ and ^ suffixes and A..B ranges, but not the rest of git's
+revision syntax.
+
+By default, files that already match the remote branch are left out, and commits left without any
+files are skipped, so fewer commits may be pushed than were given. Skipped commits are logged, and
+listed in the "skipped" field of the JSON output. Commits that were empty to begin with are still
+pushed. Use --no-skip-unchanged to push every commit as it is.
+
 Note that the pushed commits will not share the same commit sha, and you should avoid operating on
 the local checkout after running this command.
 
@@ -51,23 +101,66 @@ pushed commits, you should hard reset the local checkout to the remote version a
 }
 
 func (c *PushCmd) Run() error {
+	changes, err := c.changes()
+	if err != nil {
+		result := newPushResult(c.remoteFlags)
+		return result.fail(outwriter, c.Output, err)
+	}
+
+	return pushChanges(context.Background(), c.remoteFlags, changes...)
+}
+
+// changes reads the commits to push from the local repository
+func (c *PushCmd) changes() ([]Change, error) {
+	if c.Since != "" {
+		if len(c.Commits) != 0 {
+			return nil, errors.New("cannot use --since together with a list of commits")
+		}
+		c.Commits = []string{c.Since + "..HEAD"}
+	}
+
 	if len(c.Commits) == 0 {
 		var err error
 		c.Commits, err = commitsFromStdin(os.Stdin)
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
 
 	// Convert c.Commits into []Change which we can feed to the remote
-	repo := &Repository{path: c.RepoPath}
+	repo := openRepository(c.RepoPath, c.GitReader, c.FlattenMerges)
+	defer repo.Close()
 
-	changes, err := repo.Changes(c.Commits...)
+	commits, err := repo.resolveCommits(c.Commits...)
 	if err != nil {
-		return fmt.Errorf("get changes: %w", err)
+		return nil, err
 	}
 
-	owner, repository := c.Target.Owner(), c.Target.Repository()
+	changes, err := repo.Changes(commits...)
+	if err != nil {
+		return nil, fmt.Errorf("get changes: %w", err)
+	}
+
+	if c.OriginalHash {
+		for i := range changes {
+			changes[i].trailers = append(changes[i].trailers, fmt.Sprintf("Original-commit: %s", changes[i].hash))
+		}
+	}
+
+	// Commits hold the pointers to files stored in LFS, which are recognised by their content and
+	// by the attributes in the commit
+	if c.LFS != lfsIgnore {
+		for _, change := range changes {
+			attrs, err := repo.attributes(change.hash, slices.Collect(maps.Keys(change.entries)))
+			if err != nil {
+				return nil, fmt.Errorf("read attributes of %s: %w", change.hash, err)
+			}
+
+			if err := checkLFS(c.LFS, attrs, []Change{change}); err != nil {
+				return nil, err
+			}
+		}
+	}
 
-	return pushChanges(context.Background(), owner, repository, c.Branch, c.HeadSha, c.CreateBranch, c.DryRun, changes...)
+	return changes, nil
 }
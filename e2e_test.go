@@ -0,0 +1,551 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/DataDog/commit-headless/githubtest"
+)
+
+// remoteRepo sets up a local repository with a single commit on main, and a fake GitHub server
+// backed by a bare clone of it.
+func remoteRepo(t *testing.T) (*testRepository, *githubtest.Server) {
+	t.Helper()
+
+	logwriter = io.Discard
+	t.Setenv("HEADLESS_TOKEN", "token")
+
+	tr := testRepo(t)
+	requireNoError(t, os.WriteFile(tr.path("README"), []byte("hello"), 0o644))
+	tr.git("add", "-A")
+	tr.git("commit", "--message", "initial commit")
+	tr.git("branch", "-M", "main")
+
+	bare := t.TempDir()
+	tr.git("clone", "--bare", tr.root, bare)
+
+	return tr, githubtest.NewServer(t, "owner/repo", bare)
+}
+
+func testFlags(server *githubtest.Server, branch string) remoteFlags {
+	return remoteFlags{
+		Target:     "owner/repo",
+		Branch:     branch,
+		APIURL:     apiURLFlag(server.URL),
+		Backend:    backendGraphQL,
+		Output:     outputText,
+		Oversized:  oversizedIgnore,
+		LFS:        lfsFail,
+		MaxPayload: 1 << 20,
+
+		SkipUnchanged: true,
+	}
+}
+
+func captureOutput(t *testing.T) *bytes.Buffer {
+	out := &bytes.Buffer{}
+	outwriter = out
+	t.Cleanup(func() { outwriter = nil })
+	return out
+}
+
+func TestEndToEndPush(t *testing.T) {
+	tr, server := remoteRepo(t)
+	out := captureOutput(t)
+
+	requireNoError(t, os.WriteFile(tr.path("README"), []byte("hello world"), 0o644))
+	requireNoError(t, os.WriteFile(tr.path("new-file"), []byte("new"), 0o644))
+	tr.git("add", "-A")
+	tr.git("commit", "--message", "first change")
+	first := strings.TrimSpace(string(tr.git("rev-parse", "HEAD")))
+
+	requireNoError(t, os.Remove(tr.path("new-file")))
+	tr.git("add", "-A")
+	tr.git("commit", "--message", "second change")
+	second := strings.TrimSpace(string(tr.git("rev-parse", "HEAD")))
+
+	cmd := &PushCmd{remoteFlags: testFlags(server, "main"), RepoPath: tr.root, Commits: []string{first, second}}
+	requireNoError(t, cmd.Run())
+
+	head := server.Head("main")
+	if strings.TrimSpace(out.String()) != head {
+		t.Fatalf("expected the new head %s on stdout, got %q", head, out.String())
+	}
+
+	// The remote commits are different, but their trees must match the local ones exactly
+	localTree := strings.TrimSpace(string(tr.git("rev-parse", "HEAD^{tree}")))
+	remoteTree := strings.TrimSpace(string(tr.git("--git-dir", server.Path, "rev-parse", head+"^{tree}")))
+	if localTree != remoteTree {
+		t.Fatalf("remote tree %s does not match local tree %s", remoteTree, localTree)
+	}
+
+	messages := tr.git("--git-dir", server.Path, "log", "--format=%s", "main")
+	if string(messages) != "second change\nfirst change\ninitial commit\n" {
+		t.Fatalf("unexpected remote history: %q", messages)
+	}
+}
+
+func TestEndToEndCommitCreateBranch(t *testing.T) {
+	tr, server := remoteRepo(t)
+	out := captureOutput(t)
+
+	base := strings.TrimSpace(string(tr.git("rev-parse", "HEAD")))
+
+	requireNoError(t, os.WriteFile(tr.path("generated.txt"), []byte("generated"), 0o644))
+	requireNoError(t, os.Remove(tr.path("README")))
+	t.Chdir(tr.root)
+
+	flags := testFlags(server, "bot-branch")
+	flags.HeadSha = base
+	flags.CreateBranch = true
+
+	cmd := &CommitCmd{remoteFlags: flags, Message: []string{"update generated files"}, Force: true, Files: []string{"generated.txt", "README"}}
+	requireNoError(t, cmd.Run())
+
+	head := server.Head("bot-branch")
+	if head == "" || strings.TrimSpace(out.String()) != head {
+		t.Fatalf("expected bot-branch to be created at %q, got %q", out.String(), head)
+	}
+
+	files := tr.git("--git-dir", server.Path, "ls-tree", "--name-only", head)
+	if string(files) != "generated.txt\n" {
+		t.Fatalf("unexpected remote files: %q", files)
+	}
+
+	if server.Head("main") != base {
+		t.Fatal("expected main to be untouched")
+	}
+}
+
+func TestEndToEndStaleHead(t *testing.T) {
+	tr, server := remoteRepo(t)
+	captureOutput(t)
+
+	stale := strings.TrimSpace(string(tr.git("rev-parse", "HEAD")))
+
+	// Move the remote ahead of the local checkout
+	requireNoError(t, os.WriteFile(tr.path("file"), []byte("one"), 0o644))
+	t.Chdir(tr.root)
+	requireNoError(t, (&CommitCmd{remoteFlags: testFlags(server, "main"), Files: []string{"file"}}).Run())
+
+	moved := server.Head("main")
+
+	flags := testFlags(server, "main")
+	flags.HeadSha = stale
+
+	err := (&CommitCmd{remoteFlags: flags, Files: []string{"file"}}).Run()
+	if !errors.Is(err, ErrHeadMoved) {
+		t.Fatalf("expected ErrHeadMoved, got %v", err)
+	}
+
+	if server.Head("main") != moved {
+		t.Fatal("expected the remote not to change")
+	}
+}
+
+func TestEndToEndPushLFSPointer(t *testing.T) {
+	tr, server := remoteRepo(t)
+	captureOutput(t)
+
+	before := server.Head("main")
+
+	requireNoError(t, os.WriteFile(tr.path("image.psd"), []byte(testLFSPointer), 0o644))
+	tr.git("add", "-A")
+	tr.git("commit", "--message", "add image")
+
+	cmd := &PushCmd{remoteFlags: testFlags(server, "main"), RepoPath: tr.root, Commits: []string{"HEAD"}}
+	err := cmd.Run()
+	if err == nil || !strings.Contains(err.Error(), "image.psd") {
+		t.Fatalf("expected an error listing image.psd, got %v", err)
+	}
+
+	if server.Head("main") != before {
+		t.Fatal("expected the remote not to change")
+	}
+
+	cmd.LFS = lfsPointers
+	requireNoError(t, cmd.Run())
+
+	if server.Head("main") == before {
+		t.Fatal("expected the pointer to be pushed")
+	}
+}
+
+func TestEndToEndPushLFSAttributes(t *testing.T) {
+	tr, server := remoteRepo(t)
+	captureOutput(t)
+
+	before := server.Head("main")
+
+	// A file that .gitattributes stores in LFS, committed without git-lfs installed
+	requireNoError(t, os.MkdirAll(tr.path("assets"), 0o755))
+	requireNoError(t, os.WriteFile(tr.path("assets", ".gitattributes"), []byte("*.psd filter=lfs\n"), 0o644))
+	requireNoError(t, os.WriteFile(tr.path("assets", "image.psd"), []byte("real content"), 0o644))
+	tr.git("add", "-A")
+	tr.git("commit", "--message", "add image")
+
+	cmd := &PushCmd{remoteFlags: testFlags(server, "main"), RepoPath: tr.root, Commits: []string{"HEAD"}}
+	err := cmd.Run()
+	if err == nil || !strings.Contains(err.Error(), "assets/image.psd") {
+		t.Fatalf("expected an error listing assets/image.psd, got %v", err)
+	}
+
+	cmd.LFS = lfsPointers
+	if err := cmd.Run(); err == nil {
+		t.Fatal("expected pushing pointers to still refuse regular content")
+	}
+
+	if server.Head("main") != before {
+		t.Fatal("expected the remote not to change")
+	}
+
+	cmd.LFS = lfsIgnore
+	requireNoError(t, cmd.Run())
+
+	if server.Head("main") == before {
+		t.Fatal("expected the file to be pushed")
+	}
+}
+
+func TestEndToEndCommitLFSAttributes(t *testing.T) {
+	tr, server := remoteRepo(t)
+	captureOutput(t)
+
+	// The attributes at the top of the worktree apply to files under --repo-path
+	requireNoError(t, os.MkdirAll(tr.path("build"), 0o755))
+	requireNoError(t, os.WriteFile(tr.path(".gitattributes"), []byte("build/*.psd filter=lfs\n"), 0o644))
+	requireNoError(t, os.WriteFile(tr.path("build", "image.psd"), []byte("real content"), 0o644))
+
+	cmd := &CommitCmd{remoteFlags: testFlags(server, "main"), RepoPath: tr.path("build"), Files: []string{"image.psd"}}
+	err := cmd.Run()
+	if err == nil || !strings.Contains(err.Error(), "image.psd") {
+		t.Fatalf("expected an error listing image.psd, got %v", err)
+	}
+
+	cmd.LFS = lfsIgnore
+	requireNoError(t, cmd.Run())
+}
+
+func TestEndToEndCommitAllChanges(t *testing.T) {
+	tr, server := remoteRepo(t)
+	captureOutput(t)
+
+	requireNoError(t, os.WriteFile(tr.path("README"), []byte("formatted"), 0o644))
+	requireNoError(t, os.WriteFile(tr.path("new-file"), []byte("new"), 0o644))
+	t.Chdir(tr.root)
+
+	cmd := &CommitCmd{remoteFlags: testFlags(server, "main"), Message: []string{"format"}, AllChanges: true}
+	requireNoError(t, cmd.Run())
+
+	// The remote now matches the worktree
+	tr.git("add", "-A")
+	localTree := strings.TrimSpace(string(tr.git("write-tree")))
+	remoteTree := strings.TrimSpace(string(tr.git("--git-dir", server.Path, "rev-parse", "main^{tree}")))
+	if localTree != remoteTree {
+		t.Fatalf("remote tree %s does not match local tree %s", remoteTree, localTree)
+	}
+
+	err := (&CommitCmd{remoteFlags: testFlags(server, "main"), AllChanges: true, Files: []string{"README"}}).Run()
+	if err == nil {
+		t.Fatal("expected an error using --all-changes with a list of files")
+	}
+}
+
+func TestEndToEndCommitMirrorDirectory(t *testing.T) {
+	tr, server := remoteRepo(t)
+	captureOutput(t)
+
+	requireNoError(t, os.MkdirAll(tr.path("dist", "nested"), 0o755))
+	for _, name := range []string{"dist/app.js", "dist/old.js", "dist/nested/lib.js", "dist/app.js.map"} {
+		requireNoError(t, os.WriteFile(tr.path(name), []byte(name), 0o644))
+	}
+	t.Chdir(tr.root)
+
+	cmd := &CommitCmd{remoteFlags: testFlags(server, "main"), Files: []string{"dist/"}}
+	requireNoError(t, cmd.Run())
+
+	requireNoError(t, os.Remove(tr.path("dist", "old.js")))
+	requireNoError(t, os.Remove(tr.path("dist", "app.js.map")))
+	requireNoError(t, os.WriteFile(tr.path("dist", "new.js"), []byte("new"), 0o644))
+
+	// Without --force, files missing locally are left alone
+	cmd = &CommitCmd{remoteFlags: testFlags(server, "main"), Files: []string{"dist/**/*.js"}}
+	requireNoError(t, cmd.Run())
+
+	files := tr.git("--git-dir", server.Path, "ls-tree", "-r", "--name-only", "main")
+	if string(files) != "README\ndist/app.js\ndist/app.js.map\ndist/nested/lib.js\ndist/new.js\ndist/old.js\n" {
+		t.Fatalf("unexpected remote files: %q", files)
+	}
+
+	// With --force, the remote directory is made to match, apart from excluded files
+	cmd = &CommitCmd{remoteFlags: testFlags(server, "main"), Force: true, Exclude: []string{"*.map"}, Files: []string{"dist"}}
+	requireNoError(t, cmd.Run())
+
+	files = tr.git("--git-dir", server.Path, "ls-tree", "-r", "--name-only", "main")
+	if string(files) != "README\ndist/app.js\ndist/app.js.map\ndist/nested/lib.js\ndist/new.js\n" {
+		t.Fatalf("unexpected remote files: %q", files)
+	}
+}
+
+func TestEndToEndPrefix(t *testing.T) {
+	tr, server := remoteRepo(t)
+	captureOutput(t)
+
+	// Commit files from a build directory to docs/api on the remote
+	build := t.TempDir()
+	requireNoError(t, os.MkdirAll(filepath.Join(build, "api"), 0o755))
+	requireNoError(t, os.WriteFile(filepath.Join(build, "api", "index.html"), []byte("index"), 0o644))
+
+	flags := testFlags(server, "main")
+	flags.Prefix = "docs"
+	requireNoError(t, (&CommitCmd{remoteFlags: flags, RepoPath: build, Files: []string{"api"}}).Run())
+
+	files := tr.git("--git-dir", server.Path, "ls-tree", "-r", "--name-only", "main")
+	if string(files) != "README\ndocs/api/index.html\n" {
+		t.Fatalf("unexpected remote files: %q", files)
+	}
+
+	// Push a local commit under site/ to the root of the remote
+	tr.git("fetch", "--quiet", server.Path, "main")
+	tr.git("reset", "--quiet", "--hard", "FETCH_HEAD")
+	requireNoError(t, os.MkdirAll(tr.path("site"), 0o755))
+	requireNoError(t, os.WriteFile(tr.path("site", "page.html"), []byte("page"), 0o644))
+	tr.git("add", "-A")
+	tr.git("commit", "--message", "add page")
+
+	flags = testFlags(server, "main")
+	flags.StripPrefix = "site/"
+	requireNoError(t, (&PushCmd{remoteFlags: flags, RepoPath: tr.root, Commits: []string{"HEAD"}}).Run())
+
+	files = tr.git("--git-dir", server.Path, "ls-tree", "-r", "--name-only", "main")
+	if string(files) != "README\ndocs/api/index.html\npage.html\n" {
+		t.Fatalf("unexpected remote files: %q", files)
+	}
+
+	// Mirror a directory under a prefix, leaving an excluded file alone
+	dist := filepath.Join(build, "dist")
+	requireNoError(t, os.MkdirAll(dist, 0o755))
+	requireNoError(t, os.WriteFile(filepath.Join(dist, "keep.txt"), []byte("keep"), 0o644))
+	requireNoError(t, os.WriteFile(filepath.Join(dist, "stale.txt"), []byte("stale"), 0o644))
+
+	flags = testFlags(server, "main")
+	flags.Prefix = "docs"
+	requireNoError(t, (&CommitCmd{remoteFlags: flags, RepoPath: build, Files: []string{"dist"}}).Run())
+
+	requireNoError(t, os.Remove(filepath.Join(dist, "keep.txt")))
+	requireNoError(t, os.Remove(filepath.Join(dist, "stale.txt")))
+	requireNoError(t, os.WriteFile(filepath.Join(dist, "new.txt"), []byte("new"), 0o644))
+
+	cmd := &CommitCmd{remoteFlags: flags, RepoPath: build, Files: []string{"dist"}, Force: true, Exclude: []string{"dist/keep.txt"}}
+	requireNoError(t, cmd.Run())
+
+	files = tr.git("--git-dir", server.Path, "ls-tree", "-r", "--name-only", "main")
+	if string(files) != "README\ndocs/api/index.html\ndocs/dist/keep.txt\ndocs/dist/new.txt\npage.html\n" {
+		t.Fatalf("unexpected remote files: %q", files)
+	}
+}
+
+func TestEndToEndNothingToCommit(t *testing.T) {
+	tr, server := remoteRepo(t)
+	out := captureOutput(t)
+	t.Chdir(tr.root)
+
+	head := server.Head("main")
+
+	// README already has this content on the remote
+	cmd := &CommitCmd{remoteFlags: testFlags(server, "main"), Files: []string{"README"}}
+	requireNoError(t, cmd.Run())
+
+	if server.Head("main") != head {
+		t.Fatal("expected no commit to be created")
+	}
+
+	if strings.TrimSpace(out.String()) != head {
+		t.Fatalf("expected the unchanged head %s on stdout, got %q", head, out.String())
+	}
+
+	out.Reset()
+	cmd.Output = outputJSON
+	cmd.NoopExitCode = 3
+
+	var status exitStatus
+	if err := cmd.Run(); !errors.As(err, &status) || status != 3 {
+		t.Fatalf("expected exit status 3, got %v", err)
+	}
+
+	if !strings.Contains(out.String(), `"nothing_to_commit": true`) {
+		t.Fatalf("expected the JSON output to report nothing to commit, got %s", out.String())
+	}
+
+	// Without comparing, the commit is created anyway
+	out.Reset()
+	cmd = &CommitCmd{remoteFlags: testFlags(server, "main"), Files: []string{"README"}}
+	cmd.SkipUnchanged = false
+	requireNoError(t, cmd.Run())
+
+	if server.Head("main") == head {
+		t.Fatal("expected an empty commit to be created")
+	}
+}
+
+func TestEndToEndMissingDeletions(t *testing.T) {
+	tr, server := remoteRepo(t)
+	captureOutput(t)
+	t.Chdir(tr.root)
+
+	head := server.Head("main")
+	requireNoError(t, os.WriteFile(tr.path("new"), []byte("new"), 0o644))
+
+	cmd := &CommitCmd{remoteFlags: testFlags(server, "main"), Force: true, Files: []string{"new", "missing", "also-missing"}}
+	err := cmd.Run()
+
+	var missing *MissingDeletionsError
+	if !errors.As(err, &missing) || strings.Join(missing.Paths, " ") != "also-missing missing" {
+		t.Fatalf("expected an error listing both missing files, got %v", err)
+	}
+
+	if server.Head("main") != head {
+		t.Fatal("expected the remote not to change")
+	}
+
+	cmd.IgnoreMissing = true
+	requireNoError(t, cmd.Run())
+
+	files := tr.git("--git-dir", server.Path, "ls-tree", "-r", "--name-only", "main")
+	if string(files) != "README\nnew\n" {
+		t.Fatalf("unexpected remote files: %q", files)
+	}
+}
+
+func TestEndToEndMessageTemplate(t *testing.T) {
+	tr, server := remoteRepo(t)
+	captureOutput(t)
+
+	requireNoError(t, os.WriteFile(tr.path("README"), []byte("hello world"), 0o644))
+	tr.git("add", "-A")
+	tr.git("commit", "--message", "update readme")
+	hash := strings.TrimSpace(string(tr.git("rev-parse", "HEAD")))
+
+	flags := testFlags(server, "main")
+	flags.MessageTemplate = "[sync] {{.Headline}}"
+	flags.Trailers = []string{"Synced-by=bot"}
+	cmd := &PushCmd{remoteFlags: flags, RepoPath: tr.root, Commits: []string{"HEAD"}, OriginalHash: true}
+	requireNoError(t, cmd.Run())
+
+	message := strings.TrimSpace(string(tr.git("--git-dir", server.Path, "log", "-1", "--format=%B", "main")))
+	if !strings.HasPrefix(message, "[sync] update readme\n\n") ||
+		!strings.Contains(message, "\nSynced-by: bot") ||
+		!strings.Contains(message, "\nOriginal-commit: "+hash) {
+		t.Fatalf("unexpected message %q", message)
+	}
+}
+
+func TestEndToEndOpenPullRequestFails(t *testing.T) {
+	tr, server := remoteRepo(t)
+	out := captureOutput(t)
+
+	requireNoError(t, os.WriteFile(tr.path("README"), []byte("hello world"), 0o644))
+	tr.git("add", "-A")
+	tr.git("commit", "--message", "update readme")
+
+	// The fake server doesn't implement pull requests, so opening one fails after the push
+	flags := testFlags(server, "main")
+	flags.OpenPR = true
+	cmd := &PushCmd{remoteFlags: flags, RepoPath: tr.root, Commits: []string{"HEAD"}}
+	if err := cmd.Run(); err == nil {
+		t.Fatal("expected opening the pull request to fail")
+	}
+
+	if head := server.Head("main"); strings.TrimSpace(out.String()) != head {
+		t.Fatalf("expected the new head %s on stdout, got %q", head, out.String())
+	}
+
+	out.Reset()
+	requireNoError(t, os.WriteFile(tr.path("README"), []byte("hello again"), 0o644))
+	tr.git("commit", "--all", "--message", "update readme again")
+
+	cmd.Output = outputJSON
+	if err := cmd.Run(); err == nil {
+		t.Fatal("expected opening the pull request to fail")
+	}
+
+	result := pushResult{}
+	requireNoError(t, json.Unmarshal(out.Bytes(), &result))
+	if result.EndHead != server.Head("main") || len(result.Commits) != 1 || !strings.HasPrefix(result.Error, "open pull request:") {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+}
+
+func TestEndToEndSkipUnchangedCommits(t *testing.T) {
+	tr, server := remoteRepo(t)
+	out := captureOutput(t)
+
+	requireNoError(t, os.WriteFile(tr.path("README"), []byte("hello world"), 0o644))
+	tr.git("commit", "--all", "--message", "update readme")
+	requireNoError(t, (&PushCmd{remoteFlags: testFlags(server, "main"), RepoPath: tr.root, Commits: []string{"HEAD"}}).Run())
+	out.Reset()
+
+	// The same change made again locally matches the remote, unlike an intentionally empty commit
+	tr.git("commit", "--amend", "--message", "update readme again")
+	noop := strings.TrimSpace(string(tr.git("rev-parse", "HEAD")))
+	tr.git("commit", "--allow-empty", "--message", "empty")
+
+	flags := testFlags(server, "main")
+	flags.Output = outputJSON
+	cmd := &PushCmd{remoteFlags: flags, RepoPath: tr.root, Commits: []string{"HEAD~2..HEAD"}}
+	requireNoError(t, cmd.Run())
+
+	result := pushResult{}
+	requireNoError(t, json.Unmarshal(out.Bytes(), &result))
+	if len(result.Commits) != 1 || result.Commits[0].Headline != "empty" {
+		t.Fatalf("expected only the empty commit to be pushed, got %+v", result.Commits)
+	}
+
+	if !slices.Equal(result.Skipped, []string{noop}) {
+		t.Fatalf("expected %s to be skipped, got %q", noop, result.Skipped)
+	}
+}
+
+func TestEndToEndJSONErrors(t *testing.T) {
+	tr, server := remoteRepo(t)
+	out := captureOutput(t)
+	t.Chdir(tr.root)
+
+	flags := testFlags(server, "main")
+	flags.Output = outputJSON
+
+	// Failing to read the local files
+	cmd := &CommitCmd{remoteFlags: flags, Files: []string{"missing"}}
+	if err := cmd.Run(); err == nil {
+		t.Fatal("expected an error for a missing file")
+	}
+
+	result := pushResult{}
+	requireNoError(t, json.Unmarshal(out.Bytes(), &result))
+	if result.Owner != "owner" || result.Branch != "main" || !strings.Contains(result.Error, "missing") {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+
+	// Failing before anything is pushed
+	out.Reset()
+	cmd = &CommitCmd{remoteFlags: flags, Files: []string{"README"}}
+	cmd.HeadSha = strings.Repeat("1", 40)
+	cmd.CreateBranch = true
+	cmd.Branch = "new-branch"
+	if err := cmd.Run(); err == nil {
+		t.Fatal("expected an error for a missing head commit")
+	}
+
+	result = pushResult{}
+	requireNoError(t, json.Unmarshal(out.Bytes(), &result))
+	if result.Error == "" || len(result.Commits) != 0 || result.BranchCreated {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+}
@@ -4,44 +4,220 @@ import (
 	"bufio"
 	"bytes"
 	"fmt"
+	"io/fs"
 	"os/exec"
 	"strings"
 )
 
+// gitReader reads the commits to push from a local repository. It is implemented by [Repository],
+// which runs the git binary, and by [nativeRepository], which reads the repository files directly.
+type gitReader interface {
+	// resolveCommits turns revisions into full commit hashes, see [Repository.resolveCommits]
+	resolveCommits(revs ...string) ([]string, error)
+
+	// Changes returns a Change for each commit, see [Repository.Changes]
+	Changes(commits ...string) ([]Change, error)
+
+	// attributes returns the .gitattributes files in commit that apply to paths, see
+	// [readAttributes]
+	attributes(commit string, paths []string) (gitAttributes, error)
+
+	Close() error
+}
+
+// Values for --git-reader
+const (
+	gitReaderAuto   = "auto"
+	gitReaderExec   = "exec"
+	gitReaderNative = "native"
+)
+
+// openRepository returns a gitReader for the repository at path. With the auto reader, git is used
+// if it can be found on the PATH and the repository is otherwise read directly.
+func openRepository(path, reader string, flattenMerges bool) gitReader {
+	if reader == gitReaderAuto {
+		reader = gitReaderExec
+		if _, err := exec.LookPath("git"); err != nil {
+			log("No git binary found, reading the repository directly.\n")
+			reader = gitReaderNative
+		}
+	}
+
+	if reader == gitReaderNative {
+		return &nativeRepository{path: path, flattenMerges: flattenMerges}
+	}
+
+	return &Repository{path: path, flattenMerges: flattenMerges}
+}
+
+// objectReader reads objects, such as commits and blobs, from a repository
+type objectReader interface {
+	// read returns the contents of the object named by rev, which must be of type typ
+	read(rev, typ string) ([]byte, error)
+}
+
+// treeDiffer returns the changed files of each commit relative to the matching base, or the
+// commit's first parent if the base is empty. See [Repository.diffTrees].
+type treeDiffer func(commits, bases []string) ([][]diffEntry, error)
+
+// Repository reads commits by running git in the repository at path
 type Repository struct {
 	path string
+
+	// catfile is started on demand, see [Repository.objects]
+	catfile *catFile
+
+	// flattenMerges allows merge commits, which are pushed as a single-parent commit containing
+	// their changes relative to the first parent
+	flattenMerges bool
 }
 
-// Returns a Change for each supplied commit
+// resolveCommits turns a list of revisions into full commit hashes, oldest first within each
+// revision. Revisions may be commit hashes, references such as HEAD or tag names, or revision
+// ranges such as origin/main..HEAD, which are expanded with git rev-list.
+// Merge commits in a range are an error unless merges are being flattened, in which case only the
+// first parent history of the range is used.
+func (r *Repository) resolveCommits(revs ...string) ([]string, error) {
+	commits := []string{}
+	for _, rev := range revs {
+		if !strings.Contains(rev, "..") {
+			cmd := exec.Command("git", "rev-parse", "--verify", "--end-of-options", rev+"^{commit}")
+			cmd.Dir = r.path
+			out, err := cmd.Output()
+			if err != nil {
+				return nil, fmt.Errorf("resolve %q: not a commit", rev)
+			}
+
+			commits = append(commits, strings.TrimSpace(string(out)))
+			continue
+		}
+
+		args := []string{"rev-list", "--reverse", "--parents"}
+		if r.flattenMerges {
+			// A flattened merge already contains the changes from the merged branch, so don't push
+			// those commits separately
+			args = append(args, "--first-parent")
+		}
+
+		cmd := exec.Command("git", append(args, "--end-of-options", rev)...)
+		cmd.Dir = r.path
+		out, err := cmd.Output()
+		if err != nil {
+			return nil, fmt.Errorf("resolve range %q: %w", rev, err)
+		}
+
+		// Each line is the commit followed by its parents
+		if len(bytes.TrimSpace(out)) == 0 {
+			return nil, fmt.Errorf("range %q contains no commits", rev)
+		}
+
+		for _, ln := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+			fs := strings.Fields(ln)
+			if len(fs) > 2 && !r.flattenMerges {
+				return nil, fmt.Errorf("range %q includes a merge commit (%s), not continuing (see --flatten-merges)", rev, fs[0])
+			}
+			commits = append(commits, fs[0])
+		}
+	}
+
+	return commits, nil
+}
+
+// Returns a Change for each supplied commit, ordered by parentage regardless of the order of
+// commits. The commits must form a single linear chain, see [orderChanges].
+//
+// Commits and file contents are read through a single git cat-file process, and the changed files
+// for every commit come from a single git diff-tree process.
 func (r *Repository) Changes(commits ...string) ([]Change, error) {
+	objects, err := r.objects()
+	if err != nil {
+		return nil, err
+	}
+
+	return buildChanges(objects, r.diffTrees, r.flattenMerges, commits...)
+}
+
+// attributes returns the .gitattributes files in commit that apply to paths
+func (r *Repository) attributes(commit string, paths []string) (gitAttributes, error) {
+	objects, err := r.objects()
+	if err != nil {
+		return gitAttributes{}, err
+	}
+
+	return readAttributes(func(p string) ([]byte, error) { return commitFile(objects, commit, p) }, ".", paths)
+}
+
+// Close stops any long-lived git processes started by the repository
+func (r *Repository) Close() error {
+	if r.catfile == nil {
+		return nil
+	}
+
+	err := r.catfile.Close()
+	r.catfile = nil
+	return err
+}
+
+// objects returns the cat-file process for the repository, starting it if needed
+func (r *Repository) objects() (*catFile, error) {
+	if r.catfile == nil {
+		cf, err := newCatFile(r.path)
+		if err != nil {
+			return nil, err
+		}
+		r.catfile = cf
+	}
+
+	return r.catfile, nil
+}
+
+// buildChanges returns a Change for each commit, reading commits and file contents from objects
+// and the changed files of each commit from diffTrees, ordered by parentage.
+func buildChanges(objects objectReader, diffTrees treeDiffer, flattenMerges bool, commits ...string) ([]Change, error) {
 	changes := make([]Change, len(commits))
+	bases := make([]string, len(commits))
 	for i, h := range commits {
-		change, err := r.changed(h)
+		change, base, err := changed(objects, h, flattenMerges)
 		if err != nil {
 			return nil, fmt.Errorf("get change %s: %w", h, err)
 		}
-		changes[i] = change
+		changes[i], bases[i] = change, base
 	}
-	return changes, nil
+
+	diffs, err := diffTrees(commits, bases)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range changes {
+		changes[i].entries, changes[i].modes, err = readEntries(objects, diffs[i])
+		if err != nil {
+			return nil, fmt.Errorf("get change %s: %w", changes[i].hash, err)
+		}
+	}
+
+	return orderChanges(changes)
 }
 
-// Returns a Change for the specific commit
-func (r *Repository) changed(commit string) (Change, error) {
+// Returns a Change for the specific commit, without its entries, and the commit to compute the
+// entries against (empty to use the commit's own parent)
+func changed(objects objectReader, commit string, flattenMerges bool) (Change, string, error) {
 	// First, make sure the commit looks like a commit hash
 	// While technically all of our calls would work with references such as HEAD,
-	// refs/heads/branch, refs/tags/etc we're going to require callers provide things that look like
-	// commits.
+	// refs/heads/branch, refs/tags/etc we're going to require callers resolve them first, see
+	// [gitReader.resolveCommits].
 	if !hashRegex.MatchString(commit) {
-		return Change{}, fmt.Errorf("commit %q does not look like a commit, should be at least 4 hexadecimal digits.", commit)
+		return Change{}, "", fmt.Errorf("commit %q does not look like a commit, should be at least 4 hexadecimal digits.", commit)
 	}
 
-	parents, author, message, err := r.catfile(commit)
+	data, err := objects.read(commit, "commit")
 	if err != nil {
-		return Change{}, err
+		return Change{}, "", err
 	}
 
-	if len(parents) > 1 {
-		return Change{}, fmt.Errorf("range includes a merge commit (%s), not continuing", commit)
+	parents, author, message, err := parseCommit(data)
+	if err != nil {
+		return Change{}, "", err
 	}
 
 	change := Change{
@@ -51,26 +227,32 @@ func (r *Repository) changed(commit string) (Change, error) {
 		entries: map[string][]byte{},
 	}
 
-	change.entries, err = r.changedFiles(commit)
-	if err != nil {
-		return Change{}, err
+	if len(parents) > 0 {
+		change.parent = parents[0]
 	}
 
-	return change, nil
-}
+	// base is the commit to diff against, empty to let git use the commit's own parent
+	base := ""
+	if len(parents) > 1 {
+		if !flattenMerges {
+			return Change{}, "", fmt.Errorf("range includes a merge commit (%s), not continuing (see --flatten-merges)", commit)
+		}
 
-func (r *Repository) catfile(commit string) ([]string, string, string, error) {
-	cmd := exec.Command("git", "cat-file", "commit", commit)
-	cmd.Dir = r.path
-	out, err := cmd.Output()
-	if err != nil {
-		return nil, "", "", err
+		base = parents[0]
+		for _, p := range parents[1:] {
+			change.trailers = append(change.trailers, fmt.Sprintf("Merge-parent: %s", p))
+		}
 	}
 
+	return change, base, nil
+}
+
+// parseCommit returns the parents, author and message of a raw commit object
+func parseCommit(data []byte) ([]string, string, string, error) {
 	parents := []string{}
 	author, message := "", ""
 
-	scanner := bufio.NewScanner(bytes.NewReader(out))
+	scanner := bufio.NewScanner(bytes.NewReader(data))
 	for scanner.Scan() {
 		ln := scanner.Text()
 
@@ -114,51 +296,218 @@ func (r *Repository) catfile(commit string) ([]string, string, string, error) {
 	return parents, author, message, nil
 }
 
-// Returns the files changed in the given commit, along with their contents
-// Deleted files will have an empty value
-func (r *Repository) changedFiles(commit string) (map[string][]byte, error) {
-	cmd := exec.Command("git", "diff-tree", "--no-commit-id", "--name-status", "-r", commit)
+// diffEntry is a single entry of git diff-tree --raw output
+type diffEntry struct {
+	srcMode, dstMode string
+	srcSha, dstSha   string
+	status           string
+	path             string
+}
+
+// diffTrees returns the raw diff entries for each commit, relative to the matching base (or the
+// commit's parent if the base is empty), using a single git diff-tree process.
+func (r *Repository) diffTrees(commits, bases []string) ([][]diffEntry, error) {
+	input := &strings.Builder{}
+	for i, c := range commits {
+		// Any commits after the first on a line are diffed against as if they were its parents
+		input.WriteString(strings.TrimSpace(c + " " + bases[i]))
+		input.WriteString("\n")
+	}
+
+	cmd := exec.Command("git", "diff-tree", "--stdin", "--always", "--raw", "-r", "-z", "--no-renames")
 	cmd.Dir = r.path
+	cmd.Stdin = strings.NewReader(input.String())
 	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git diff-tree: %w", err)
+	}
+
+	diffs, err := parseRawDiff(out)
 	if err != nil {
 		return nil, err
 	}
 
+	if len(diffs) != len(commits) {
+		return nil, fmt.Errorf("git diff-tree: got %d diffs for %d commits", len(diffs), len(commits))
+	}
+
+	return diffs, nil
+}
+
+// parseRawDiff splits git diff-tree --stdin --raw -z output into the entries for each commit.
+// Every commit's entries are preceded by the commit hash. Fields are separated by NUL bytes, so
+// paths are exactly as stored in the repository, without any quoting.
+func parseRawDiff(out []byte) ([][]diffEntry, error) {
+	diffs := [][]diffEntry{}
+
+	fields := splitNUL(out)
+	for len(fields) > 0 {
+		if !strings.HasPrefix(fields[0], ":") {
+			diffs = append(diffs, []diffEntry{})
+			fields = fields[1:]
+			continue
+		}
+
+		if len(diffs) == 0 {
+			return nil, fmt.Errorf("unexpected diff-tree output %q", fields[0])
+		}
+
+		e, rest, err := parseRawEntry(fields)
+		if err != nil {
+			return nil, err
+		}
+
+		diffs[len(diffs)-1] = append(diffs[len(diffs)-1], e)
+		fields = rest
+	}
+
+	return diffs, nil
+}
+
+// parseRawEntries parses --raw -z output comparing a single pair of trees, such as from git
+// diff-index, which unlike git diff-tree --stdin has no commit hash before the entries.
+func parseRawEntries(out []byte) ([]diffEntry, error) {
+	entries := []diffEntry{}
+
+	fields := splitNUL(out)
+	for len(fields) > 0 {
+		e, rest, err := parseRawEntry(fields)
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, e)
+		fields = rest
+	}
+
+	return entries, nil
+}
+
+// parseRawEntry parses the entry at the start of fields, returning it and the fields after it.
+// Each entry is
+//
+//	:<src mode> <dst mode> <src sha> <dst sha> <status>\0<path>\0[<path>\0]
+func parseRawEntry(fields []string) (diffEntry, []string, error) {
+	meta := fields[0]
+
+	info := strings.Fields(strings.TrimPrefix(meta, ":"))
+	if !strings.HasPrefix(meta, ":") || len(info) != 5 {
+		return diffEntry{}, nil, fmt.Errorf("unexpected raw diff output %q", meta)
+	}
+
+	e := diffEntry{
+		srcMode: info[0], dstMode: info[1],
+		srcSha: info[2], dstSha: info[3],
+		status: info[4],
+	}
+
+	// Renames and copies are never detected, so every entry has a single path
+	if len(fields) < 2 {
+		return diffEntry{}, nil, fmt.Errorf("missing path in raw diff output after %q", meta)
+	}
+
+	e.path = fields[1]
+	return e, fields[2:], nil
+}
+
+// splitNUL splits NUL terminated output into its fields
+func splitNUL(out []byte) []string {
+	if len(out) == 0 {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(string(out), "\x00"), "\x00")
+}
+
+// readEntries returns the contents of the files changed by the diff entries, and the modes of any
+// that are not regular files. Submodules point at commits in another repository, so their content is
+// the commit hash rather than an object read from objects.
+// Deleted files will have an empty value. Statuses that can't be represented as a commit, such as
+// unmerged paths, are an error.
+func readEntries(objects objectReader, diff []diffEntry) (map[string][]byte, map[string]string, error) {
 	changes := map[string][]byte{}
-	scanner := bufio.NewScanner(bytes.NewReader(out))
-	for scanner.Scan() {
-		ln := scanner.Text()
+	modes := map[string]string{}
 
-		status, value, _ := strings.Cut(ln, "\t")
-		switch {
-		case status == "A" || status == "M":
-			contents, err := r.fileContent(commit, value)
-			if err != nil {
-				return nil, fmt.Errorf("get content %s:%s: %w", commit, value, err)
-			}
-			changes[value] = contents
-		case strings.HasPrefix(status, "R"): // Renames may have a similarity score after the R
-			from, to, _ := strings.Cut(value, "\t")
-			changes[from] = nil
-			contents, err := r.fileContent(commit, to)
-			if err != nil {
-				return nil, fmt.Errorf("get content %s:%s: %w", commit, to, err)
+	// add records the content and mode of a path that exists after the commit
+	add := func(e diffEntry) error {
+		if e.dstMode == modeSubmodule {
+			changes[e.path] = []byte(e.dstSha)
+			modes[e.path] = e.dstMode
+			return nil
+		}
+
+		contents, err := objects.read(e.dstSha, "blob")
+		if err != nil {
+			return fmt.Errorf("get content %s: %w", e.path, err)
+		}
+		changes[e.path] = contents
+		if e.dstMode != modeRegular {
+			modes[e.path] = e.dstMode
+		}
+		return nil
+	}
+
+	for _, e := range diff {
+		// Renames and copies are never detected (see --no-renames), so they are unsupported like
+		// any other status
+		switch e.status {
+		case "A", "M", "T":
+			// Type changes, such as a file replaced by a symlink, are a modification with a new mode
+			if err := add(e); err != nil {
+				return nil, nil, err
 			}
-			changes[to] = contents
-		case status == "D":
-			changes[value] = nil
+		case "D":
+			changes[e.path] = nil
+		case "U":
+			return nil, nil, fmt.Errorf("%s is unmerged", e.path)
+		case "X":
+			return nil, nil, fmt.Errorf("git reported an unknown change to %s", e.path)
+		default:
+			return nil, nil, fmt.Errorf("unsupported change status %q for %s", e.status, e.path)
 		}
 	}
 
-	if err := scanner.Err(); err != nil {
+	return changes, modes, nil
+}
+
+// commitFile returns the contents of the file at p in commit, or an error wrapping fs.ErrNotExist
+// if there is no such file
+func commitFile(objects objectReader, commit, p string) ([]byte, error) {
+	data, err := objects.read(commit, "commit")
+	if err != nil {
 		return nil, err
 	}
 
-	return changes, nil
-}
+	// The tree is the first header of a commit
+	header, _, _ := bytes.Cut(data, []byte("\n"))
+	tree, ok := strings.CutPrefix(string(header), "tree ")
+	if !ok {
+		return nil, fmt.Errorf("commit %s has no tree", commit)
+	}
 
-func (r *Repository) fileContent(commit, path string) ([]byte, error) {
-	cmd := exec.Command("git", "cat-file", "blob", fmt.Sprintf("%s:%s", commit, path))
-	cmd.Dir = r.path
-	return cmd.Output()
+	elems := strings.Split(p, "/")
+	for i, name := range elems {
+		data, err := objects.read(tree, "tree")
+		if err != nil {
+			return nil, err
+		}
+
+		entries, err := parseTree(tree, data)
+		if err != nil {
+			return nil, err
+		}
+
+		entry, ok := entries[name]
+		switch {
+		case !ok:
+			return nil, fmt.Errorf("%s in %s: %w", p, commit, fs.ErrNotExist)
+		case i < len(elems)-1 && entry.mode == modeTree:
+			tree = entry.sha
+		case i == len(elems)-1 && (entry.mode == modeRegular || entry.mode == modeExecutable):
+			return objects.read(entry.sha, "blob")
+		default:
+			return nil, fmt.Errorf("%s in %s is not a file: %w", p, commit, fs.ErrNotExist)
+		}
+	}
+
+	return nil, fmt.Errorf("%s in %s: %w", p, commit, fs.ErrNotExist)
 }
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"maps"
 	"os"
 	"os/exec"
@@ -10,7 +12,7 @@ import (
 	"testing"
 )
 
-func requireNoError(t *testing.T, err error, msg ...any) {
+func requireNoError(t testing.TB, err error, msg ...any) {
 	t.Helper()
 
 	if err == nil {
@@ -31,7 +33,7 @@ func requireNoError(t *testing.T, err error, msg ...any) {
 }
 
 type testRepository struct {
-	t    *testing.T
+	t    testing.TB
 	root string
 }
 
@@ -54,7 +56,7 @@ func (tr *testRepository) path(p ...string) string {
 	return filepath.Join(append([]string{tr.root}, p...)...)
 }
 
-func testRepo(t *testing.T) *testRepository {
+func testRepo(t testing.TB) *testRepository {
 	t.Helper()
 
 	tr := &testRepository{t: t}
@@ -114,6 +116,7 @@ func TestChangedFiles(t *testing.T) {
 	hash := strings.TrimSpace(string(tr.git("rev-parse", "HEAD")))
 
 	r := &Repository{path: tr.root}
+	defer r.Close()
 
 	changes, err := r.Changes(hash)
 	requireNoError(t, err)
@@ -143,3 +146,570 @@ func TestChangedFiles(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestChangedFilesModes(t *testing.T) {
+	tr := testRepo(t)
+	tr.git("commit", "--allow-empty", "--message", "initial commit")
+
+	requireNoError(t, os.WriteFile(tr.path("script.sh"), []byte("#!/bin/sh\n"), 0o755))
+	requireNoError(t, os.WriteFile(tr.path("plain"), []byte("plain"), 0o644))
+	requireNoError(t, os.Symlink("plain", tr.path("link")))
+
+	tr.git("add", "-A")
+	tr.git("commit", "--message", "second commit")
+	hash := strings.TrimSpace(string(tr.git("rev-parse", "HEAD")))
+
+	r := &Repository{path: tr.root}
+	defer r.Close()
+
+	changes, err := r.Changes(hash)
+	requireNoError(t, err)
+
+	change := changes[0]
+
+	if m := change.mode("script.sh"); m != modeExecutable {
+		t.Errorf("expected script.sh to be executable, got mode %s", m)
+	}
+
+	if m := change.mode("link"); m != modeSymlink {
+		t.Errorf("expected link to be a symlink, got mode %s", m)
+	}
+
+	if string(change.entries["link"]) != "plain" {
+		t.Errorf("expected link content to be the link target, got %q", change.entries["link"])
+	}
+
+	if m := change.mode("plain"); m != modeRegular {
+		t.Errorf("expected plain to be a regular file, got mode %s", m)
+	}
+
+	if !change.hasSpecialModes() {
+		t.Error("expected change to report special modes")
+	}
+}
+
+func TestFlattenMerges(t *testing.T) {
+	tr := testRepo(t)
+
+	requireNoError(t, os.WriteFile(tr.path("base"), []byte("base"), 0o644))
+	tr.git("add", "-A")
+	tr.git("commit", "--message", "initial commit")
+	tr.git("branch", "-M", "main")
+
+	tr.git("checkout", "-b", "feature")
+	requireNoError(t, os.WriteFile(tr.path("feature"), []byte("feature"), 0o644))
+	tr.git("add", "-A")
+	tr.git("commit", "--message", "feature commit")
+	feature := strings.TrimSpace(string(tr.git("rev-parse", "HEAD")))
+
+	tr.git("checkout", "main")
+	requireNoError(t, os.WriteFile(tr.path("main"), []byte("main"), 0o644))
+	tr.git("add", "-A")
+	tr.git("commit", "--message", "main commit")
+
+	tr.git("merge", "--no-ff", "--message", "merge feature", "feature")
+	merge := strings.TrimSpace(string(tr.git("rev-parse", "HEAD")))
+
+	r := &Repository{path: tr.root}
+	defer r.Close()
+
+	if _, err := r.Changes(merge); err == nil {
+		t.Fatal("expected merge commits to be refused by default")
+	}
+
+	r.flattenMerges = true
+	changes, err := r.Changes(merge)
+	requireNoError(t, err)
+
+	change := changes[0]
+
+	keys := slices.Sorted(maps.Keys(change.entries))
+	if !slices.Equal(keys, []string{"feature"}) {
+		t.Fatalf("expected only the merged file to change, got %q", keys)
+	}
+
+	if change.Headline() != "merge feature" {
+		t.Errorf("expected the merge message to be kept, got %q", change.Headline())
+	}
+
+	if want := "Merge-parent: " + feature; !slices.Contains(change.trailers, want) {
+		t.Errorf("expected trailer %q, got %q", want, change.trailers)
+	}
+}
+
+func TestResolveCommits(t *testing.T) {
+	tr := testRepo(t)
+
+	hashes := []string{}
+	for _, msg := range []string{"one", "two", "three"} {
+		tr.git("commit", "--allow-empty", "--message", msg)
+		hashes = append(hashes, strings.TrimSpace(string(tr.git("rev-parse", "HEAD"))))
+	}
+	tr.git("tag", "v1", hashes[1])
+
+	r := &Repository{path: tr.root}
+
+	testcases := []struct {
+		revs []string
+		want []string
+	}{{
+		[]string{"HEAD"}, hashes[2:],
+	}, {
+		[]string{"v1", hashes[2][:8]}, hashes[1:],
+	}, {
+		[]string{hashes[0] + "..HEAD"}, hashes[1:],
+	}, {
+		[]string{"HEAD~3..HEAD"}, nil,
+	}, {
+		[]string{"HEAD~2..HEAD~1", "HEAD"}, hashes[1:],
+	}}
+
+	for _, tc := range testcases {
+		t.Run(strings.Join(tc.revs, " "), func(t *testing.T) {
+			got, err := r.resolveCommits(tc.revs...)
+			if tc.want == nil {
+				if err == nil {
+					t.Fatalf("expected an error, got %q", got)
+				}
+				return
+			}
+
+			requireNoError(t, err)
+			if !slices.Equal(got, tc.want) {
+				t.Fatalf("wrong commits, got=%q, want=%q", got, tc.want)
+			}
+		})
+	}
+
+	if _, err := r.resolveCommits("not-a-ref"); err == nil {
+		t.Fatal("expected an unknown reference to be an error")
+	}
+
+	if _, err := r.resolveCommits("HEAD..HEAD"); err == nil {
+		t.Fatal("expected an empty range to be an error")
+	}
+}
+
+func TestResolveCommitsMerge(t *testing.T) {
+	tr := testRepo(t)
+	tr.git("commit", "--allow-empty", "--message", "initial commit")
+	tr.git("branch", "-M", "main")
+	base := strings.TrimSpace(string(tr.git("rev-parse", "HEAD")))
+
+	tr.git("checkout", "-b", "feature")
+	tr.git("commit", "--allow-empty", "--message", "feature commit")
+	tr.git("checkout", "main")
+	tr.git("merge", "--no-ff", "--message", "merge feature", "feature")
+
+	if _, err := (&Repository{path: tr.root}).resolveCommits(base + "..HEAD"); err == nil {
+		t.Fatal("expected a range with a merge commit to be an error")
+	}
+
+	commits, err := (&Repository{path: tr.root, flattenMerges: true}).resolveCommits(base + "..HEAD")
+	requireNoError(t, err)
+
+	// Only the merge itself, the feature commit is part of the flattened merge
+	if merge := strings.TrimSpace(string(tr.git("rev-parse", "HEAD"))); !slices.Equal(commits, []string{merge}) {
+		t.Fatalf("expected only the merge commit, got %q", commits)
+	}
+}
+
+func TestCatFile(t *testing.T) {
+	tr := testRepo(t)
+
+	requireNoError(t, os.WriteFile(tr.path("file"), []byte("line one\nline two\n"), 0o644))
+	tr.git("add", "-A")
+	tr.git("commit", "--message", "initial commit")
+
+	cf, err := newCatFile(tr.root)
+	requireNoError(t, err)
+	defer cf.Close()
+
+	// Read a few times to make sure each response is fully consumed
+	for range 3 {
+		content, err := cf.read("HEAD:file", "blob")
+		requireNoError(t, err)
+
+		if string(content) != "line one\nline two\n" {
+			t.Fatalf("unexpected content %q", content)
+		}
+
+		if _, err := cf.read("HEAD", "blob"); err == nil {
+			t.Fatal("expected reading a commit as a blob to fail")
+		}
+
+		if _, _, err := cf.object("HEAD:missing"); !errors.Is(err, errObjectMissing) {
+			t.Fatalf("expected errObjectMissing, got %v", err)
+		}
+	}
+}
+
+func TestChangesMultipleCommits(t *testing.T) {
+	tr := testRepo(t)
+	tr.git("commit", "--allow-empty", "--message", "initial commit")
+
+	hashes := []string{}
+	for i := range 5 {
+		requireNoError(t, os.WriteFile(tr.path(fmt.Sprintf("file-%d", i)), []byte(fmt.Sprint(i)), 0o644))
+		tr.git("add", "-A")
+		tr.git("commit", "--message", fmt.Sprintf("commit %d", i))
+		hashes = append(hashes, strings.TrimSpace(string(tr.git("rev-parse", "HEAD"))))
+	}
+
+	// An empty commit in the middle must still get its own (empty) set of changes
+	tr.git("commit", "--allow-empty", "--message", "empty commit")
+	hashes = append(hashes, strings.TrimSpace(string(tr.git("rev-parse", "HEAD"))))
+
+	r := &Repository{path: tr.root}
+	defer r.Close()
+
+	changes, err := r.Changes(hashes...)
+	requireNoError(t, err)
+
+	for i, c := range changes[:5] {
+		want := fmt.Sprintf("file-%d", i)
+		if len(c.entries) != 1 || string(c.entries[want]) != fmt.Sprint(i) {
+			t.Errorf("commit %d: expected only %s, got %q", i, want, slices.Collect(maps.Keys(c.entries)))
+		}
+	}
+
+	if len(changes[5].entries) != 0 {
+		t.Errorf("expected the empty commit to have no entries, got %d", len(changes[5].entries))
+	}
+}
+
+func benchmarkChanges(b *testing.B, commits, files int) {
+	tr := testRepo(b)
+	tr.git("commit", "--allow-empty", "--message", "initial commit")
+
+	hashes := []string{}
+	for c := range commits {
+		for f := range files {
+			content := []byte(strings.Repeat(fmt.Sprintf("commit %d file %d\n", c, f), 64))
+			requireNoError(b, os.WriteFile(tr.path(fmt.Sprintf("file-%d", f)), content, 0o644))
+		}
+		tr.git("add", "-A")
+		tr.git("commit", "--message", fmt.Sprintf("commit %d", c))
+		hashes = append(hashes, strings.TrimSpace(string(tr.git("rev-parse", "HEAD"))))
+	}
+
+	b.ResetTimer()
+	for b.Loop() {
+		r := &Repository{path: tr.root}
+		_, err := r.Changes(hashes...)
+		requireNoError(b, err)
+		r.Close()
+	}
+}
+
+func BenchmarkChangesManyFiles(b *testing.B) {
+	benchmarkChanges(b, 1, 2000)
+}
+
+func BenchmarkChangesManyCommits(b *testing.B) {
+	benchmarkChanges(b, 200, 5)
+}
+
+// fakeObjects is an objectReader serving blobs from a map
+type fakeObjects map[string]string
+
+func (f fakeObjects) read(rev, typ string) ([]byte, error) {
+	data, ok := f[rev]
+	if !ok || typ != "blob" {
+		return nil, fmt.Errorf("%s: %w", rev, errObjectMissing)
+	}
+	return []byte(data), nil
+}
+
+func TestParseRawDiff(t *testing.T) {
+	out := strings.Join([]string{
+		"1111111111111111111111111111111111111111",
+		":100644 100644 aaaa bbbb M", "modified",
+		":100644 100644 aaaa bbbb M", "new\tname",
+		":100644 000000 aaaa 0000 D", ":deleted",
+		"2222222222222222222222222222222222222222",
+		":000000 100644 0000 cccc A", "added\nwith \"quotes\"",
+	}, "\x00") + "\x00"
+
+	diffs, err := parseRawDiff([]byte(out))
+	requireNoError(t, err)
+
+	want := [][]diffEntry{
+		{
+			{srcMode: "100644", dstMode: "100644", srcSha: "aaaa", dstSha: "bbbb", status: "M", path: "modified"},
+			{srcMode: "100644", dstMode: "100644", srcSha: "aaaa", dstSha: "bbbb", status: "M", path: "new\tname"},
+			{srcMode: "100644", dstMode: "000000", srcSha: "aaaa", dstSha: "0000", status: "D", path: ":deleted"},
+		},
+		{
+			{srcMode: "000000", dstMode: "100644", srcSha: "0000", dstSha: "cccc", status: "A", path: "added\nwith \"quotes\""},
+		},
+	}
+
+	if !slices.EqualFunc(diffs, want, slices.Equal) {
+		t.Fatalf("expected %+v, got %+v", want, diffs)
+	}
+
+	if _, err := parseRawDiff([]byte(":100644 100644 aaaa bbbb M\x00")); err == nil {
+		t.Error("expected an error for an entry before any commit")
+	}
+
+	if _, err := parseRawDiff([]byte("1111\x00:100644 100644 aaaa bbbb M\x00")); err == nil {
+		t.Error("expected an error for an entry without a path")
+	}
+}
+
+func TestChangedFilesSpecialCharacters(t *testing.T) {
+	tr := testRepo(t)
+	tr.git("commit", "--allow-empty", "--message", "initial commit")
+
+	names := []string{
+		"with space.txt",
+		"with\ttab",
+		"with\nnewline",
+		`with "quotes"`,
+		"héllo wörld.txt",
+		"locales/日本語.json",
+		"locales/العربية.json",
+		"back\\slash",
+	}
+
+	requireNoError(t, os.MkdirAll(tr.path("locales"), 0o755))
+	for _, name := range names {
+		requireNoError(t, os.WriteFile(tr.path(name), []byte(name), 0o644))
+	}
+	tr.git("add", "-A")
+	tr.git("commit", "--message", "special characters")
+	hash := strings.TrimSpace(string(tr.git("rev-parse", "HEAD")))
+
+	for _, r := range []gitReader{&Repository{path: tr.root}, &nativeRepository{path: tr.root}} {
+		changes, err := r.Changes(hash)
+		requireNoError(t, err)
+		r.Close()
+
+		entries := changes[0].entries
+		if !slices.Equal(slices.Sorted(maps.Keys(entries)), slices.Sorted(slices.Values(names))) {
+			t.Fatalf("%T: expected paths %q, got %q", r, names, slices.Collect(maps.Keys(entries)))
+		}
+
+		for _, name := range names {
+			if string(entries[name]) != name {
+				t.Errorf("%T: expected %q to contain its name, got %q", r, name, entries[name])
+			}
+		}
+	}
+}
+
+func TestReadEntriesStatuses(t *testing.T) {
+	objects := fakeObjects{"aaaa": "old", "bbbb": "new", "link": "target"}
+
+	testcases := []struct {
+		name    string
+		entry   diffEntry
+		entries map[string]string // "" is a deletion
+		modes   map[string]string
+		err     string
+	}{
+		{
+			name:    "added",
+			entry:   diffEntry{srcMode: "000000", dstMode: "100644", dstSha: "bbbb", status: "A", path: "file"},
+			entries: map[string]string{"file": "new"},
+		},
+		{
+			name:    "modified",
+			entry:   diffEntry{srcMode: "100644", dstMode: "100755", srcSha: "aaaa", dstSha: "bbbb", status: "M", path: "file"},
+			entries: map[string]string{"file": "new"},
+			modes:   map[string]string{"file": modeExecutable},
+		},
+		{
+			name:    "deleted",
+			entry:   diffEntry{srcMode: "100644", dstMode: "000000", srcSha: "aaaa", status: "D", path: "file"},
+			entries: map[string]string{"file": ""},
+		},
+		{
+			name:    "type change",
+			entry:   diffEntry{srcMode: "100644", dstMode: "120000", srcSha: "aaaa", dstSha: "link", status: "T", path: "file"},
+			entries: map[string]string{"file": "target"},
+			modes:   map[string]string{"file": modeSymlink},
+		},
+		{
+			name:    "submodule",
+			entry:   diffEntry{srcMode: "160000", dstMode: "160000", srcSha: "cccc", dstSha: "dddd", status: "M", path: "sub"},
+			entries: map[string]string{"sub": "dddd"},
+			modes:   map[string]string{"sub": modeSubmodule},
+		},
+		{
+			name:  "unmerged",
+			entry: diffEntry{srcMode: "000000", dstMode: "000000", status: "U", path: "file"},
+			err:   "file is unmerged",
+		},
+		{
+			name:  "unknown",
+			entry: diffEntry{srcMode: "100644", dstMode: "100644", status: "X", path: "file"},
+			err:   "unknown change to file",
+		},
+		{
+			name:  "unsupported",
+			entry: diffEntry{srcMode: "100644", dstMode: "100644", status: "Z", path: "file"},
+			err:   `unsupported change status "Z"`,
+		},
+		{
+			name:  "renamed",
+			entry: diffEntry{srcMode: "100644", dstMode: "100644", srcSha: "aaaa", dstSha: "bbbb", status: "R075", path: "new"},
+			err:   `unsupported change status "R075"`,
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			entries, modes, err := readEntries(objects, []diffEntry{tc.entry})
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("expected error containing %q, got %v", tc.err, err)
+				}
+				return
+			}
+			requireNoError(t, err)
+
+			got := map[string]string{}
+			for p, content := range entries {
+				if content == nil && tc.entries[p] != "" {
+					t.Errorf("expected %s to be changed, got a deletion", p)
+				}
+				got[p] = string(content)
+			}
+
+			if !maps.Equal(got, tc.entries) {
+				t.Errorf("expected entries %q, got %q", tc.entries, got)
+			}
+
+			if !maps.Equal(modes, tc.modes) {
+				t.Errorf("expected modes %q, got %q", tc.modes, modes)
+			}
+		})
+	}
+}
+
+func TestChangedFilesTypeChange(t *testing.T) {
+	tr := testRepo(t)
+
+	requireNoError(t, os.WriteFile(tr.path("target"), []byte("target"), 0o644))
+	requireNoError(t, os.WriteFile(tr.path("file"), []byte("file"), 0o644))
+	tr.git("add", "-A")
+	tr.git("commit", "--message", "initial commit")
+
+	requireNoError(t, os.Remove(tr.path("file")))
+	requireNoError(t, os.Symlink("target", tr.path("file")))
+	tr.git("add", "-A")
+	tr.git("commit", "--message", "replace file with a symlink")
+	hash := strings.TrimSpace(string(tr.git("rev-parse", "HEAD")))
+
+	for _, r := range []gitReader{&Repository{path: tr.root}, &nativeRepository{path: tr.root}} {
+		changes, err := r.Changes(hash)
+		requireNoError(t, err)
+		r.Close()
+
+		if c := changes[0]; string(c.entries["file"]) != "target" || c.mode("file") != modeSymlink {
+			t.Errorf("%T: expected file to become a symlink to target, got %q with mode %s", r, c.entries["file"], c.mode("file"))
+		}
+	}
+}
+
+func TestChangedFilesRename(t *testing.T) {
+	tr := testRepo(t)
+
+	requireNoError(t, os.WriteFile(tr.path("old"), []byte("content"), 0o644))
+	tr.git("add", "-A")
+	tr.git("commit", "--message", "initial commit")
+
+	// Renames and copies are reported as additions and deletions, even when configured
+	tr.git("config", "diff.renames", "copies")
+	tr.git("mv", "old", "new")
+	requireNoError(t, os.WriteFile(tr.path("copy"), []byte("content"), 0o644))
+	tr.git("add", "-A")
+	tr.git("commit", "--message", "rename and copy")
+	hash := strings.TrimSpace(string(tr.git("rev-parse", "HEAD")))
+
+	for _, r := range []gitReader{&Repository{path: tr.root}, &nativeRepository{path: tr.root}} {
+		changes, err := r.Changes(hash)
+		requireNoError(t, err)
+		r.Close()
+
+		c := changes[0]
+		if len(c.entries) != 3 || c.entries["old"] != nil || string(c.entries["new"]) != "content" || string(c.entries["copy"]) != "content" {
+			t.Errorf("%T: expected old to be deleted and new and copy added, got %q", r, c.entries)
+		}
+	}
+}
+
+func TestChangedFilesSubmodule(t *testing.T) {
+	tr := testRepo(t)
+	tr.git("commit", "--allow-empty", "--message", "initial commit")
+
+	// The submodule commits don't need to exist locally
+	first, second := strings.Repeat("1", 40), strings.Repeat("2", 40)
+
+	tr.git("update-index", "--add", "--cacheinfo", modeSubmodule+","+first+",sub")
+	tr.git("commit", "--message", "add submodule")
+
+	tr.git("update-index", "--cacheinfo", modeSubmodule+","+second+",sub")
+	tr.git("commit", "--message", "bump submodule")
+	hash := strings.TrimSpace(string(tr.git("rev-parse", "HEAD")))
+
+	for _, r := range []gitReader{&Repository{path: tr.root}, &nativeRepository{path: tr.root}} {
+		changes, err := r.Changes(hash)
+		requireNoError(t, err)
+		r.Close()
+
+		c := changes[0]
+		if string(c.entries["sub"]) != second || c.mode("sub") != modeSubmodule {
+			t.Errorf("%T: expected sub to point at %s, got %q with mode %s", r, second, c.entries["sub"], c.mode("sub"))
+		}
+
+		if subs := c.submodules(); !slices.Equal(subs, []string{"sub"}) {
+			t.Errorf("%T: expected submodules [sub], got %q", r, subs)
+		}
+	}
+}
+
+func TestRepositoryAttributes(t *testing.T) {
+	tr := testRepo(t)
+
+	requireNoError(t, os.MkdirAll(tr.path("dir", "nested"), 0o755))
+	requireNoError(t, os.WriteFile(tr.path(".gitattributes"), []byte("*.psd filter=lfs\n"), 0o644))
+	requireNoError(t, os.WriteFile(tr.path("dir", ".gitattributes"), []byte("*.zip filter=lfs\n"), 0o644))
+	requireNoError(t, os.WriteFile(tr.path("dir", "nested", "a.zip"), []byte("zip"), 0o644))
+	tr.git("add", "-A")
+	tr.git("commit", "--message", "initial commit")
+	first := strings.TrimSpace(string(tr.git("rev-parse", "HEAD")))
+
+	// Attributes are read from the commit, not the worktree
+	requireNoError(t, os.Remove(tr.path("dir", ".gitattributes")))
+	tr.git("add", "-A")
+	tr.git("commit", "--message", "remove attributes")
+	second := strings.TrimSpace(string(tr.git("rev-parse", "HEAD")))
+
+	readers := map[string]gitReader{
+		"exec":   &Repository{path: tr.root},
+		"native": &nativeRepository{path: tr.root},
+	}
+
+	for name, repo := range readers {
+		t.Run(name, func(t *testing.T) {
+			defer repo.Close()
+
+			attrs, err := repo.attributes(first, []string{"dir/nested/a.zip", "missing/a.psd"})
+			requireNoError(t, err)
+
+			if len(attrs.files) != 2 || !lfsTracked(attrs, "dir/nested/a.zip") || !lfsTracked(attrs, "missing/a.psd") {
+				t.Fatalf("unexpected attributes: %q", attrs.files)
+			}
+
+			attrs, err = repo.attributes(second, []string{"dir/nested/a.zip"})
+			requireNoError(t, err)
+
+			if len(attrs.files) != 1 || lfsTracked(attrs, "dir/nested/a.zip") {
+				t.Fatalf("unexpected attributes: %q", attrs.files)
+			}
+		})
+	}
+}
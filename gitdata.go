@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// Push backends, selected with --backend
+const (
+	// backendGraphQL creates commits with the createCommitOnBranch mutation. These commits are
+	// signed by GitHub, but cannot carry file modes or submodules.
+	backendGraphQL = "graphql"
+
+	// backendREST creates commits with the Git Data REST API, which preserves file modes but
+	// doesn't guarantee a signed commit.
+	backendREST = "rest"
+
+	// backendAuto uses the GraphQL backend unless a change contains executables, symlinks or
+	// submodules.
+	backendAuto = "auto"
+)
+
+// useGitData reports whether change should be pushed with the Git Data REST API
+func (c *Client) useGitData(change Change) bool {
+	switch c.backend {
+	case backendREST:
+		return true
+	case backendAuto:
+		if change.hasSpecialModes() {
+			log("Commit %s contains executables, symlinks or submodules, using the REST backend.\n", change.hash)
+			log("Warning: commits created with the REST backend may not be signed by GitHub.\n")
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Client) gitDataURL(kind string) string {
+	return fmt.Sprintf("%s/repos/%s/%s/git/%s", c.baseURL, c.owner, c.repo, kind)
+}
+
+// pushChangeGitData pushes a single change by creating blobs, a tree and a commit with the Git
+// Data API, and then fast-forwarding the branch to the new commit.
+// It returns the hash of the pushed commit or an error.
+func (c *Client) pushChangeGitData(ctx context.Context, headCommit string, change Change) (string, error) {
+	if c.dryrun {
+		log("Dry run enabled, not writing commit.\n")
+		return strings.Repeat("0", len(change.hash)), nil
+	}
+
+	parent := struct {
+		Tree struct {
+			Sha string
+		}
+	}{}
+
+	if _, err := c.doJSON(ctx, http.MethodGet, c.gitDataURL("commits/"+headCommit), nil, http.StatusOK, &parent); err != nil {
+		return "", fmt.Errorf("get commit %s: %w", headCommit, err)
+	}
+
+	entries := []treeEntry{}
+	for _, path := range slices.Sorted(maps.Keys(change.entries)) {
+		content := change.entries[path]
+
+		if content == nil {
+			// a null sha removes the path from the base tree
+			entries = append(entries, treeEntry{Path: path, Mode: modeRegular, Type: "blob"})
+			continue
+		}
+
+		// Submodules are tree entries pointing at a commit in another repository
+		if change.mode(path) == modeSubmodule {
+			sha := string(content)
+			entries = append(entries, treeEntry{Path: path, Mode: modeSubmodule, Type: "commit", Sha: &sha})
+			continue
+		}
+
+		sha, err := c.createBlob(ctx, content)
+		if err != nil {
+			return "", fmt.Errorf("create blob %s: %w", path, err)
+		}
+
+		entries = append(entries, treeEntry{Path: path, Mode: change.mode(path), Type: "blob", Sha: &sha})
+	}
+
+	tree := struct {
+		Sha string
+	}{}
+
+	treeInput := map[string]any{"base_tree": parent.Tree.Sha, "tree": entries}
+	if _, err := c.doJSON(ctx, http.MethodPost, c.gitDataURL("trees"), treeInput, http.StatusCreated, &tree); err != nil {
+		return "", fmt.Errorf("create tree: %w", err)
+	}
+
+	commit := struct {
+		Sha string
+	}{}
+
+	commitInput := map[string]any{
+		"message": strings.TrimSpace(change.Headline() + "\n\n" + change.Body()),
+		"tree":    tree.Sha,
+		"parents": []string{headCommit},
+	}
+	if _, err := c.doJSON(ctx, http.MethodPost, c.gitDataURL("commits"), commitInput, http.StatusCreated, &commit); err != nil {
+		return "", fmt.Errorf("create commit: %w", err)
+	}
+
+	// Without force, GitHub refuses to move the branch unless it's a fast-forward, which is how we
+	// notice the remote head moved since headCommit
+	refInput := map[string]any{"sha": commit.Sha, "force": false}
+	status, err := c.doJSON(ctx, http.MethodPatch, c.gitDataURL("refs/heads/"+c.branch), refInput, http.StatusOK, nil)
+	if status == http.StatusUnprocessableEntity {
+		return "", fmt.Errorf("%w: expected %s", ErrHeadMoved, headCommit)
+	} else if err != nil {
+		return "", fmt.Errorf("update branch: %w", err)
+	}
+
+	log("Pushed commit %s -> %s\n", change.hash, commit.Sha)
+	log("  Commit URL: %s\n", c.commitURL(commit.Sha))
+
+	return commit.Sha, nil
+}
+
+// createBlob uploads content and returns its blob sha
+func (c *Client) createBlob(ctx context.Context, content []byte) (string, error) {
+	blob := struct {
+		Sha string
+	}{}
+
+	input := map[string]any{"content": content, "encoding": "base64"}
+	if _, err := c.doJSON(ctx, http.MethodPost, c.gitDataURL("blobs"), input, http.StatusCreated, &blob); err != nil {
+		return "", err
+	}
+
+	return blob.Sha, nil
+}
+
+// checkSubmodules returns an error if any of changes updates a submodule but would be pushed with
+// the GraphQL API, which has no way to express them
+func checkSubmodules(backend string, changes []Change) error {
+	if backend != backendGraphQL {
+		return nil
+	}
+
+	for _, c := range changes {
+		if subs := c.submodules(); len(subs) > 0 {
+			return fmt.Errorf("commit %s updates submodules, which the GraphQL API cannot express "+
+				"(use --backend=rest or --backend=auto): %s", c.hash, strings.Join(subs, ", "))
+		}
+	}
+
+	return nil
+}
+
+type treeEntry struct {
+	Path string  `json:"path"`
+	Mode string  `json:"mode"`
+	Type string  `json:"type"`
+	Sha  *string `json:"sha"`
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPushChangeGitData(t *testing.T) {
+	logwriter = io.Discard
+
+	var tree struct {
+		BaseTree string      `json:"base_tree"`
+		Tree     []treeEntry `json:"tree"`
+	}
+	var refUpdate struct {
+		Sha   string
+		Force bool
+	}
+	blobs := map[string]string{}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /repos/owner/repo/git/commits/head", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"sha": "head", "tree": {"sha": "base-tree"}}`)
+	})
+	mux.HandleFunc("POST /repos/owner/repo/git/blobs", func(w http.ResponseWriter, r *http.Request) {
+		blob := struct {
+			Content  []byte
+			Encoding string
+		}{}
+		requireNoError(t, json.NewDecoder(r.Body).Decode(&blob))
+
+		sha := fmt.Sprintf("blob-%d", len(blobs))
+		blobs[sha] = string(blob.Content)
+
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, `{"sha": %q}`, sha)
+	})
+	mux.HandleFunc("POST /repos/owner/repo/git/trees", func(w http.ResponseWriter, r *http.Request) {
+		requireNoError(t, json.NewDecoder(r.Body).Decode(&tree))
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprint(w, `{"sha": "new-tree"}`)
+	})
+	mux.HandleFunc("POST /repos/owner/repo/git/commits", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprint(w, `{"sha": "new-commit"}`)
+	})
+	mux.HandleFunc("PATCH /repos/owner/repo/git/refs/heads/branch", func(w http.ResponseWriter, r *http.Request) {
+		requireNoError(t, json.NewDecoder(r.Body).Decode(&refUpdate))
+		fmt.Fprint(w, `{}`)
+	})
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	client := &Client{httpC: server.Client(), owner: "owner", repo: "repo", branch: "branch", baseURL: server.URL, backend: backendAuto}
+
+	change := Change{
+		hash:    "1234",
+		message: "add a script",
+		entries: map[string][]byte{
+			"script.sh": []byte("#!/bin/sh\n"),
+			"link":      []byte("script.sh"),
+			"removed":   nil,
+			"sub":       []byte(strings.Repeat("1", 40)),
+		},
+		modes: map[string]string{"script.sh": modeExecutable, "link": modeSymlink, "sub": modeSubmodule},
+	}
+
+	oid, err := client.PushChange(context.Background(), "head", change)
+	requireNoError(t, err)
+
+	if oid != "new-commit" {
+		t.Errorf("expected new-commit, got %s", oid)
+	}
+
+	if tree.BaseTree != "base-tree" {
+		t.Errorf("expected tree to be based on base-tree, got %s", tree.BaseTree)
+	}
+
+	got := map[string]treeEntry{}
+	for _, e := range tree.Tree {
+		got[e.Path] = e
+	}
+
+	if e := got["script.sh"]; e.Mode != modeExecutable || e.Sha == nil || blobs[*e.Sha] != "#!/bin/sh\n" {
+		t.Errorf("unexpected tree entry for script.sh: %+v", e)
+	}
+
+	if e := got["link"]; e.Mode != modeSymlink || e.Sha == nil || blobs[*e.Sha] != "script.sh" {
+		t.Errorf("unexpected tree entry for link: %+v", e)
+	}
+
+	if e := got["sub"]; e.Mode != modeSubmodule || e.Type != "commit" || e.Sha == nil || *e.Sha != strings.Repeat("1", 40) {
+		t.Errorf("unexpected tree entry for sub: %+v", e)
+	}
+
+	if len(blobs) != 2 {
+		t.Errorf("expected blobs only for script.sh and link, got %d", len(blobs))
+	}
+
+	if e, ok := got["removed"]; !ok || e.Sha != nil {
+		t.Errorf("expected removed to have a null sha, got %+v", e)
+	}
+
+	if refUpdate.Sha != "new-commit" || refUpdate.Force {
+		t.Errorf("expected a fast-forward ref update to new-commit, got %+v", refUpdate)
+	}
+}
+
+func TestUseGitData(t *testing.T) {
+	logwriter = io.Discard
+
+	regular := Change{entries: map[string][]byte{"file": []byte("x")}}
+	special := Change{entries: map[string][]byte{"file": []byte("x")}, modes: map[string]string{"file": modeExecutable}}
+	submodule := Change{entries: map[string][]byte{"sub": []byte(strings.Repeat("1", 40))}, modes: map[string]string{"sub": modeSubmodule}}
+
+	testcases := []struct {
+		backend string
+		change  Change
+		want    bool
+	}{
+		{backendGraphQL, special, false},
+		{backendREST, regular, true},
+		{backendAuto, regular, false},
+		{backendAuto, special, true},
+		{backendAuto, submodule, true},
+	}
+
+	for _, tc := range testcases {
+		if got := (&Client{backend: tc.backend}).useGitData(tc.change); got != tc.want {
+			t.Errorf("backend %s: got=%t, want=%t", tc.backend, got, tc.want)
+		}
+	}
+}
+
+func TestCheckSubmodules(t *testing.T) {
+	change := Change{
+		hash:    "1234",
+		entries: map[string][]byte{"b": []byte(strings.Repeat("1", 40)), "a": []byte(strings.Repeat("2", 40)), "removed": nil},
+		modes:   map[string]string{"a": modeSubmodule, "b": modeSubmodule, "removed": modeSubmodule},
+	}
+
+	err := checkSubmodules(backendGraphQL, []Change{change})
+	if err == nil || !strings.Contains(err.Error(), "submodules") || !strings.HasSuffix(err.Error(), ": a, b") {
+		t.Fatalf("expected an error listing a and b, got %v", err)
+	}
+
+	requireNoError(t, checkSubmodules(backendREST, []Change{change}))
+	requireNoError(t, checkSubmodules(backendAuto, []Change{change}))
+
+	// Removing a submodule is a plain deletion
+	delete(change.entries, "a")
+	delete(change.entries, "b")
+	requireNoError(t, checkSubmodules(backendGraphQL, []Change{change}))
+}
@@ -12,7 +12,10 @@ import (
 	"golang.org/x/oauth2"
 )
 
-var ErrNoRemoteBranch = errors.New("branch does not exist on the remote")
+var (
+	ErrNoRemoteBranch = errors.New("branch does not exist on the remote")
+	ErrHeadMoved      = errors.New("remote branch head does not match the expected head")
+)
 
 // Client provides methods for interacting with a remote repository on GitHub
 type Client struct {
@@ -21,24 +24,32 @@ type Client struct {
 	repo   string
 	branch string
 
-	dryrun bool
+	dryrun  bool
+	rebase  bool
+	backend string
+	retry   retryPolicy
+
+	// unchanged lists paths left out of the push because they already matched the remote, which
+	// count as conflicts when rebasing, see [Client.rebaseOnto]
+	unchanged []string
 
-	// Used for testing purposes
-	baseURL string
+	// Base URLs for the REST API, GraphQL API and web interface, see [apiURLFlag]
+	baseURL    string
+	graphqlURL string
+	webURL     string
 }
 
 // NewClient returns a Client configured to make GitHub requests for branch owned by owner/repo on
-// GitHub using the oauth token in token.
-func NewClient(ctx context.Context, token, owner, repo, branch string) *Client {
-	tokensrc := oauth2.StaticTokenSource(
-		&oauth2.Token{AccessToken: token},
-	)
-
+// GitHub using tokens from tokensrc. The API endpoints are derived from api.
+func NewClient(ctx context.Context, tokensrc oauth2.TokenSource, api apiURLFlag, owner, repo, branch string) *Client {
 	httpC := oauth2.NewClient(ctx, tokensrc)
 	return &Client{
 		httpC: httpC,
 		owner: owner, repo: repo, branch: branch,
-		baseURL: "https://api.github.com",
+		baseURL:    api.REST(),
+		graphqlURL: api.GraphQL(),
+		webURL:     api.Web(),
+		retry:      defaultRetryPolicy,
 	}
 }
 
@@ -46,20 +57,20 @@ func (c *Client) branchURL() string {
 	return fmt.Sprintf("%s/repos/%s/%s/branches/%s", c.baseURL, c.owner, c.repo, c.branch)
 }
 
+func (c *Client) compareURL(base, head string) string {
+	return fmt.Sprintf("%s/repos/%s/%s/compare/%s...%s", c.baseURL, c.owner, c.repo, base, head)
+}
+
 func (c *Client) refsURL() string {
 	return fmt.Sprintf("%s/repos/%s/%s/git/refs", c.baseURL, c.owner, c.repo)
 }
 
 func (c *Client) browseCommitsURL() string {
-	return fmt.Sprintf("https://github.com/%s/%s/commits/%s", c.owner, c.repo, c.branch)
+	return fmt.Sprintf("%s/%s/%s/commits/%s", c.webURL, c.owner, c.repo, c.branch)
 }
 
 func (c *Client) commitURL(hash string) string {
-	return fmt.Sprintf("https://github.com/%s/%s/commit/%s", c.owner, c.repo, hash)
-}
-
-func (c *Client) graphqlURL() string {
-	return fmt.Sprintf("%s/graphql", c.baseURL)
+	return fmt.Sprintf("%s/%s/%s/commit/%s", c.webURL, c.owner, c.repo, hash)
 }
 
 // GetHeadCommitHash returns the current head commit hash for the configured repository and branch
@@ -69,7 +80,7 @@ func (c *Client) GetHeadCommitHash(ctx context.Context) (string, error) {
 		return "", fmt.Errorf("prepare http request: %w", err)
 	}
 
-	resp, err := c.httpC.Do(req)
+	resp, err := c.do(req, nil)
 	if err != nil {
 		return "", fmt.Errorf("get commit hash: %w", err)
 	}
@@ -115,8 +126,23 @@ func (c *Client) CreateBranch(ctx context.Context, headSha string) (string, erro
 		return "", fmt.Errorf("prepare http request: %w", err)
 	}
 
-	resp, err := c.httpC.Do(req)
-	if err != nil {
+	// If an earlier attempt created the branch but we never saw the response, a retry would fail
+	// because the reference already exists, so check for it first
+	resp, err := c.do(req, func() error {
+		sha, err := c.GetHeadCommitHash(ctx)
+		if errors.Is(err, ErrNoRemoteBranch) {
+			return nil
+		} else if err != nil {
+			return err
+		} else if sha != headSha {
+			return fmt.Errorf("branch %q already exists at %s", c.branch, sha)
+		}
+		return errLanded
+	})
+	if errors.Is(err, errLanded) {
+		log("Branch was created by an earlier attempt.\n")
+		return headSha, nil
+	} else if err != nil {
 		return "", fmt.Errorf("create branch request: %w", err)
 	}
 	defer resp.Body.Close()
@@ -142,21 +168,127 @@ func (c *Client) CreateBranch(ctx context.Context, headSha string) (string, erro
 	return payload.Commit.Sha, nil
 }
 
+// doJSON sends a request with in encoded as the JSON body, if not nil, and decodes the response
+// into out if the response has the status code want.
+// It returns the response status code, along with any error.
+func (c *Client) doJSON(ctx context.Context, method, url string, in any, want int, out any) (int, error) {
+	var body bytes.Buffer
+	if in != nil {
+		if err := json.NewEncoder(&body).Encode(in); err != nil {
+			return 0, fmt.Errorf("encode request: %w", err)
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, &body)
+	if err != nil {
+		return 0, fmt.Errorf("prepare http request: %w", err)
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	// Everything sent through here is safe to repeat: reads, content addressed objects, and
+	// non-forced reference updates
+	resp, err := c.do(req, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != want {
+		return resp.StatusCode, fmt.Errorf("http %d", resp.StatusCode)
+	}
+
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return resp.StatusCode, fmt.Errorf("decode response: %w", err)
+		}
+	}
+
+	return resp.StatusCode, nil
+}
+
+// ChangedPaths returns the paths touched by the commits between base and head, which must be a
+// descendant of base. Renamed files are reported under both their old and new names.
+func (c *Client) ChangedPaths(ctx context.Context, base, head string) ([]string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.compareURL(base, head), nil)
+	if err != nil {
+		return nil, fmt.Errorf("prepare http request: %w", err)
+	}
+
+	resp, err := c.do(req, nil)
+	if err != nil {
+		return nil, fmt.Errorf("compare commits: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("compare commits: http %d", resp.StatusCode)
+	}
+
+	payload := struct {
+		Status string
+		Files  []struct {
+			Filename         string
+			PreviousFilename string `json:"previous_filename"`
+		}
+	}{}
+
+	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+		return nil, fmt.Errorf("decode compare response: %w", err)
+	}
+
+	if payload.Status != "ahead" {
+		return nil, fmt.Errorf("remote head %s is not a descendant of %s (status %q)", head, base, payload.Status)
+	}
+
+	// The compare API stops listing files after this many, so we can't tell what else changed
+	if len(payload.Files) >= maxCompareFiles {
+		return nil, fmt.Errorf("too many files changed between %s and %s to compare", base, head)
+	}
+
+	paths := []string{}
+	for _, f := range payload.Files {
+		paths = append(paths, f.Filename)
+		if f.PreviousFilename != "" {
+			paths = append(paths, f.PreviousFilename)
+		}
+	}
+
+	return paths, nil
+}
+
 // PushChanges takes a list of changes and a commit hash and produces commits using the GitHub GraphQL API.
 // The commit hash is expected to be the current head of the remote branch, see [GetHeadCommitHash]
 // for more.
-// It returns the number of changes that were successfully pushed, the new head reference hash, and
-// any error encountered.
-func (c *Client) PushChanges(ctx context.Context, headCommit string, changes ...Change) (int, string, error) {
-	var err error
-	for i, change := range changes {
-		headCommit, err = c.PushChange(ctx, headCommit, change)
+// If rebasing is enabled and the remote head moves while pushing, the remaining changes are retried
+// on top of the new head, see [Client.rebaseOnto].
+// It returns the hashes of the commits created for each change that was successfully pushed, in
+// order, and any error encountered.
+func (c *Client) PushChanges(ctx context.Context, headCommit string, changes ...Change) ([]string, error) {
+	pushed := []string{}
+	rebases := 0
+	for i := 0; i < len(changes); i++ {
+		newHead, err := c.PushChange(ctx, headCommit, changes[i])
+		if errors.Is(err, ErrHeadMoved) && c.rebase && rebases < maxRebases {
+			rebases++
+			headCommit, err = c.rebaseOnto(ctx, headCommit, changes[i:])
+			if err != nil {
+				return pushed, fmt.Errorf("rebase change %d: %w", i+1, err)
+			}
+
+			// retry the same change on top of the new head
+			i--
+			continue
+		}
+
 		if err != nil {
-			return i + 1, "", fmt.Errorf("push change %d: %w", i+i, err)
+			return pushed, fmt.Errorf("push change %d: %w", i+1, err)
 		}
+
+		headCommit = newHead
+		pushed = append(pushed, newHead)
 	}
 
-	return len(changes), headCommit, nil
+	return pushed, nil
 }
 
 // Splits a Change into added and deleted slices, taking into account existing files vs empty files
@@ -177,9 +309,57 @@ func (c *Client) splitChange(change Change) (added, deleted []fileChange) {
 	return added, deleted
 }
 
-// PushChange pushes a single change using the GraphQL API.
+// PushChange pushes a single change using the configured backend, see [Client.useGitData].
 // It returns the hash of the pushed commit or an error.
 func (c *Client) PushChange(ctx context.Context, headCommit string, change Change) (string, error) {
+	if c.useGitData(change) {
+		return c.pushChangeGitData(ctx, headCommit, change)
+	}
+
+	if err := checkSubmodules(backendGraphQL, []Change{change}); err != nil {
+		return "", err
+	}
+
+	if change.hasSpecialModes() {
+		log("Warning: commit %s contains executables or symlinks, their modes will be lost.\n", change.hash)
+	}
+
+	return c.pushChangeGraphQL(ctx, headCommit, change)
+}
+
+// landedCommit checks whether change was already committed on top of headCommit, which can
+// happen when a request succeeds but the response is lost. It returns the hash of the remote
+// commit if so, or an empty string if the remote head hasn't moved.
+func (c *Client) landedCommit(ctx context.Context, headCommit string, change Change) (string, error) {
+	head, err := c.GetHeadCommitHash(ctx)
+	if err != nil {
+		return "", err
+	} else if head == headCommit {
+		return "", nil
+	}
+
+	commit := struct {
+		Message string
+		Parents []struct {
+			Sha string
+		}
+	}{}
+
+	if _, err := c.doJSON(ctx, http.MethodGet, c.gitDataURL("commits/"+head), nil, http.StatusOK, &commit); err != nil {
+		return "", fmt.Errorf("get commit %s: %w", head, err)
+	}
+
+	message := strings.TrimSpace(change.Headline() + "\n\n" + change.Body())
+	if len(commit.Parents) == 1 && commit.Parents[0].Sha == headCommit && strings.TrimSpace(commit.Message) == message {
+		return head, nil
+	}
+
+	return "", fmt.Errorf("%w: expected %s, found %s", ErrHeadMoved, headCommit, head)
+}
+
+// pushChangeGraphQL pushes a single change using the GraphQL API.
+// It returns the hash of the pushed commit or an error.
+func (c *Client) pushChangeGraphQL(ctx context.Context, headCommit string, change Change) (string, error) {
 	// Turn the change into a createCommitOnBranchInput
 	added, deleted := c.splitChange(change)
 
@@ -223,16 +403,36 @@ func (c *Client) PushChange(ctx context.Context, headCommit string, change Chang
 		return strings.Repeat("0", len(change.hash)), nil
 	}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.graphqlURL(), bytes.NewReader(queryJSON))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.graphqlURL, bytes.NewReader(queryJSON))
 	if err != nil {
 		return "", fmt.Errorf("prepare mutation request: %w", err)
 	}
 
-	resp, err := c.httpC.Do(req)
-	defer resp.Body.Close()
-	if err != nil {
+	// The mutation isn't idempotent, so before retrying make sure the commit didn't land anyway
+	var landed string
+	resp, err := c.do(req, func() error {
+		oid, err := c.landedCommit(ctx, headCommit, change)
+		if err != nil {
+			return err
+		} else if oid != "" {
+			landed = oid
+			return errLanded
+		}
+		return nil
+	})
+	if errors.Is(err, errLanded) {
+		log("Commit %s was created by an earlier attempt.\n", change.hash)
+		log("Pushed commit %s -> %s\n", change.hash, landed)
+		log("  Commit URL: %s\n", c.commitURL(landed))
+		return landed, nil
+	} else if err != nil {
 		return "", err
 	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("create commit: http %d", resp.StatusCode)
+	}
 
 	payload := struct {
 		Data struct {
@@ -243,6 +443,7 @@ func (c *Client) PushChange(ctx context.Context, headCommit string, change Chang
 			} `json:"createCommitOnBranch"`
 		}
 		Errors []struct {
+			Type    string
 			Message string
 		}
 	}{}
@@ -253,11 +454,19 @@ func (c *Client) PushChange(ctx context.Context, headCommit string, change Chang
 
 	if len(payload.Errors) != 0 {
 		log("There were %d errors returned when creating the commit.\n", len(payload.Errors))
+		headMoved := false
 		for _, e := range payload.Errors {
 			log("  - %s\n", e.Message)
+			if e.Type == "STALE_DATA" || strings.Contains(e.Message, "Expected branch to point to") {
+				headMoved = true
+			}
+		}
+
+		if headMoved {
+			return "", fmt.Errorf("%w: expected %s", ErrHeadMoved, headCommit)
 		}
 
-		return "", errors.New("graphql response")
+		return "", fmt.Errorf("graphql response: %s", payload.Errors[0].Message)
 	}
 
 	oid := payload.Data.CreateCommitOnBranch.Commit.ObjectID
@@ -0,0 +1,336 @@
+// Package githubtest provides a fake GitHub API server backed by a bare git repository on disk.
+//
+// It implements the small part of the GitHub API that commit-headless relies on to push commits:
+// looking up a branch, creating a branch, listing trees, and the createCommitOnBranch GraphQL
+// mutation. Like GitHub, the mutation is rejected if expectedHeadOid doesn't match the head of the
+// branch. This allows pushes to be tested end to end without network access.
+package githubtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Committer is the identity used for commits created through the mutation
+const Committer = "GitHub <noreply@github.com>"
+
+// Server is a fake GitHub API for a single repository
+type Server struct {
+	*httptest.Server
+
+	// NameWithOwner is the owner/repo the server answers for
+	NameWithOwner string
+
+	// Path is the path to the bare repository backing the server
+	Path string
+
+	tb testing.TB
+	mu sync.Mutex
+}
+
+// NewServer starts a fake GitHub API serving nameWithOwner from the bare repository at path.
+// The server is closed when the test finishes.
+func NewServer(tb testing.TB, nameWithOwner, path string) *Server {
+	tb.Helper()
+
+	s := &Server{NameWithOwner: nameWithOwner, Path: path, tb: tb}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /repos/{owner}/{repo}/branches/{branch...}", s.repository(s.getBranch))
+	mux.HandleFunc("POST /repos/{owner}/{repo}/git/refs", s.repository(s.createRef))
+	mux.HandleFunc("GET /repos/{owner}/{repo}/git/trees/{sha}", s.repository(s.getTree))
+	mux.HandleFunc("POST /graphql", s.graphql)
+
+	s.Server = httptest.NewServer(mux)
+	tb.Cleanup(s.Close)
+
+	return s
+}
+
+// Head returns the commit the branch points to, or an empty string if it doesn't exist
+func (s *Server) Head(branch string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out, err := s.git(nil, nil, "rev-parse", "--verify", "--quiet", "refs/heads/"+branch)
+	if err != nil {
+		return ""
+	}
+	return out
+}
+
+// git runs a git command in the repository, returning its trimmed standard output
+func (s *Server) git(env []string, stdin []byte, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = s.Path
+	cmd.Env = append(os.Environ(), env...)
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %w: %s", strings.Join(args, " "), err, stderr.String())
+	}
+
+	return strings.TrimSpace(string(out)), nil
+}
+
+// repository wraps a REST handler, only serving requests for the configured repository
+func (s *Server) repository(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.PathValue("owner")+"/"+r.PathValue("repo") != s.NameWithOwner {
+			writeJSON(w, http.StatusNotFound, map[string]string{"message": "Not Found"})
+			return
+		}
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		h(w, r)
+	}
+}
+
+func (s *Server) getBranch(w http.ResponseWriter, r *http.Request) {
+	branch := r.PathValue("branch")
+
+	sha, err := s.git(nil, nil, "rev-parse", "--verify", "--quiet", "refs/heads/"+branch)
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"message": "Branch not found"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"name":   branch,
+		"commit": map[string]string{"sha": sha},
+	})
+}
+
+func (s *Server) createRef(w http.ResponseWriter, r *http.Request) {
+	input := struct {
+		Ref string
+		Sha string
+	}{}
+
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"message": "Problems parsing JSON"})
+		return
+	}
+
+	if !strings.HasPrefix(input.Ref, "refs/") || strings.Count(input.Ref, "/") < 2 {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"message": "Reference name is invalid"})
+		return
+	}
+
+	if _, err := s.git(nil, nil, "cat-file", "-e", input.Sha+"^{commit}"); err != nil {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"message": "Object does not exist"})
+		return
+	}
+
+	// An empty old value means the reference must not already exist
+	if _, err := s.git(nil, nil, "update-ref", input.Ref, input.Sha, ""); err != nil {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"message": "Reference already exists"})
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, map[string]any{
+		"ref":    input.Ref,
+		"object": map[string]string{"sha": input.Sha, "type": "commit"},
+	})
+}
+
+// getTree lists the tree of a commit or tree, including subtrees with ?recursive=1
+func (s *Server) getTree(w http.ResponseWriter, r *http.Request) {
+	tree, err := s.git(nil, nil, "rev-parse", "--verify", "--quiet", r.PathValue("sha")+"^{tree}")
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"message": "Not Found"})
+		return
+	}
+
+	args := []string{"ls-tree", "-z", "--full-tree"}
+	if r.URL.Query().Has("recursive") {
+		args = append(args, "-r", "-t")
+	}
+
+	out, err := s.git(nil, nil, append(args, tree)...)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"message": err.Error()})
+		return
+	}
+
+	// Each entry is "<mode> <type> <sha>\t<path>"
+	entries := []map[string]string{}
+	for _, e := range strings.Split(out, "\x00") {
+		info, path, ok := strings.Cut(e, "\t")
+		fields := strings.Fields(info)
+		if !ok || len(fields) != 3 {
+			continue
+		}
+
+		entries = append(entries, map[string]string{"path": path, "mode": fields[0], "type": fields[1], "sha": fields[2]})
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{"sha": tree, "tree": entries, "truncated": false})
+}
+
+type createCommitOnBranchInput struct {
+	Branch struct {
+		Name   string `json:"branchName"`
+		Target string `json:"repositoryNameWithOwner"`
+	} `json:"branch"`
+	ExpectedHeadOid string `json:"expectedHeadOid"`
+	Message         struct {
+		Headline string `json:"headline"`
+		Body     string `json:"body"`
+	} `json:"message"`
+	FileChanges struct {
+		Additions []struct {
+			Path     string `json:"path"`
+			Contents []byte `json:"contents"`
+		} `json:"additions"`
+		Deletions []struct {
+			Path string `json:"path"`
+		} `json:"deletions"`
+	} `json:"fileChanges"`
+}
+
+func (s *Server) graphql(w http.ResponseWriter, r *http.Request) {
+	query := struct {
+		Query     string
+		Variables struct {
+			Input createCommitOnBranchInput
+		}
+	}{}
+
+	if err := json.NewDecoder(r.Body).Decode(&query); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"message": "Problems parsing JSON"})
+		return
+	}
+
+	if !strings.Contains(query.Query, "createCommitOnBranch") {
+		writeGraphQLError(w, "", "githubtest only implements the createCommitOnBranch mutation")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	oid, errType, err := s.createCommitOnBranch(query.Variables.Input)
+	if err != nil {
+		writeGraphQLError(w, errType, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"data": map[string]any{
+			"createCommitOnBranch": map[string]any{
+				"commit": map[string]string{"oid": oid},
+			},
+		},
+	})
+}
+
+// createCommitOnBranch applies the mutation to the repository, returning the new commit, or the
+// GraphQL error type and message GitHub would respond with.
+func (s *Server) createCommitOnBranch(input createCommitOnBranchInput) (string, string, error) {
+	if input.Branch.Target != s.NameWithOwner {
+		return "", "NOT_FOUND", fmt.Errorf("Could not resolve to a Repository with the name '%s'.", input.Branch.Target)
+	}
+
+	ref := "refs/heads/" + input.Branch.Name
+	head, err := s.git(nil, nil, "rev-parse", "--verify", "--quiet", ref)
+	if err != nil {
+		return "", "NOT_FOUND", fmt.Errorf("A ref named \"%s\" does not exist", ref)
+	}
+
+	if head != input.ExpectedHeadOid {
+		return "", "STALE_DATA", fmt.Errorf("Expected branch to point to \"%s\" but it did not. Pull and try again.", input.ExpectedHeadOid)
+	}
+
+	// Build the new tree in a private index so concurrent pushes can't interfere
+	index := filepath.Join(s.tb.TempDir(), "index")
+	env := []string{"GIT_INDEX_FILE=" + index}
+
+	if _, err := s.git(env, nil, "read-tree", head); err != nil {
+		return "", "", err
+	}
+
+	// Entries for update-index --index-info, where a zero mode removes the path
+	entries := &bytes.Buffer{}
+
+	for _, d := range input.FileChanges.Deletions {
+		if _, err := s.git(nil, nil, "cat-file", "-e", head+":"+d.Path); err != nil {
+			return "", "", fmt.Errorf("A path was requested for deletion which does not exist as of commit oid `%s`", head)
+		}
+
+		fmt.Fprintf(entries, "0 %s\t%s\x00", strings.Repeat("0", 40), d.Path)
+	}
+
+	for _, a := range input.FileChanges.Additions {
+		blob, err := s.git(nil, a.Contents, "hash-object", "-w", "--stdin")
+		if err != nil {
+			return "", "", err
+		}
+
+		// Like GitHub, file modes can't be set and every file is a regular file
+		fmt.Fprintf(entries, "100644 %s\t%s\x00", blob, a.Path)
+	}
+
+	if _, err := s.git(env, entries.Bytes(), "update-index", "-z", "--index-info"); err != nil {
+		return "", "", err
+	}
+
+	tree, err := s.git(env, nil, "write-tree")
+	if err != nil {
+		return "", "", err
+	}
+
+	message := input.Message.Headline
+	if input.Message.Body != "" {
+		message += "\n\n" + input.Message.Body
+	}
+
+	name, email, _ := strings.Cut(strings.TrimSuffix(Committer, ">"), " <")
+	identity := []string{
+		"GIT_AUTHOR_NAME=" + name, "GIT_AUTHOR_EMAIL=" + email,
+		"GIT_COMMITTER_NAME=" + name, "GIT_COMMITTER_EMAIL=" + email,
+	}
+
+	commit, err := s.git(identity, []byte(message), "commit-tree", tree, "-p", head)
+	if err != nil {
+		return "", "", err
+	}
+
+	if _, err := s.git(nil, nil, "update-ref", ref, commit, head); err != nil {
+		return "", "STALE_DATA", fmt.Errorf("Expected branch to point to \"%s\" but it did not. Pull and try again.", input.ExpectedHeadOid)
+	}
+
+	return commit, "", nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, payload any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(payload)
+}
+
+func writeGraphQLError(w http.ResponseWriter, errType, message string) {
+	e := map[string]string{"message": message}
+	if errType != "" {
+		e["type"] = errType
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{"errors": []map[string]string{e}})
+}
@@ -0,0 +1,140 @@
+package githubtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func git(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %s: %s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func TestCreateCommitOnBranch(t *testing.T) {
+	bare := t.TempDir()
+	git(t, bare, "init", "--bare", "--initial-branch=main")
+	tree := git(t, bare, "mktree")
+	head := git(t, bare, "-c", "user.name=A U Thor", "-c", "user.email=author@home.arpa", "commit-tree", tree, "-m", "initial commit")
+	git(t, bare, "update-ref", "refs/heads/main", head)
+
+	server := NewServer(t, "owner/repo", bare)
+
+	mutate := func(expected string) map[string]any {
+		input := map[string]any{
+			"branch":          map[string]string{"branchName": "main", "repositoryNameWithOwner": "owner/repo"},
+			"expectedHeadOid": expected,
+			"message":         map[string]string{"headline": "add a file", "body": ""},
+			"fileChanges": map[string]any{
+				"additions": []map[string]any{{"path": "dir/file", "contents": []byte("hello")}},
+			},
+		}
+
+		body, _ := json.Marshal(map[string]any{
+			"query":     "mutation ($input: CreateCommitOnBranchInput!) { createCommitOnBranch(input: $input) { commit { oid } } }",
+			"variables": map[string]any{"input": input},
+		})
+
+		resp, err := http.Post(server.URL+"/graphql", "application/json", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		out := map[string]any{}
+		if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+
+	out := mutate(head)
+	if out["errors"] != nil {
+		t.Fatalf("unexpected errors: %v", out["errors"])
+	}
+
+	newHead := server.Head("main")
+	if newHead == head {
+		t.Fatal("expected the branch to move")
+	}
+
+	if content := git(t, bare, "cat-file", "blob", newHead+":dir/file"); content != "hello" {
+		t.Errorf("unexpected content %q", content)
+	}
+
+	if committer := git(t, bare, "log", "-1", "--format=%cn <%ce>", newHead); committer != Committer {
+		t.Errorf("unexpected committer %q", committer)
+	}
+
+	// The branch has moved, so using the old head again must fail
+	out = mutate(head)
+	errs, _ := out["errors"].([]any)
+	if len(errs) != 1 || errs[0].(map[string]any)["type"] != "STALE_DATA" {
+		t.Fatalf("expected a STALE_DATA error, got %v", out)
+	}
+
+	if server.Head("main") != newHead {
+		t.Fatal("expected the branch not to move")
+	}
+}
+
+func TestGetTree(t *testing.T) {
+	bare := t.TempDir()
+	git(t, bare, "init", "--bare", "--initial-branch=main")
+	blob := strings.TrimSpace(runStdin(t, bare, "hello", "hash-object", "-w", "--stdin"))
+	sub := strings.TrimSpace(runStdin(t, bare, "100644 blob "+blob+"\tfile\n", "mktree"))
+	tree := strings.TrimSpace(runStdin(t, bare, "040000 tree "+sub+"\tdir\n100755 blob "+blob+"\ttop\n", "mktree"))
+	head := git(t, bare, "-c", "user.name=A U Thor", "-c", "user.email=author@home.arpa", "commit-tree", tree, "-m", "initial commit")
+
+	server := NewServer(t, "owner/repo", bare)
+
+	resp, err := http.Get(server.URL + "/repos/owner/repo/git/trees/" + head + "?recursive=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	out := struct {
+		Sha  string
+		Tree []struct{ Path, Mode, Type, Sha string }
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		t.Fatal(err)
+	}
+
+	if out.Sha != tree || len(out.Tree) != 3 {
+		t.Fatalf("expected tree %s with 3 entries, got %+v", tree, out)
+	}
+
+	got := map[string]string{}
+	for _, e := range out.Tree {
+		got[e.Path] = e.Mode + " " + e.Type
+	}
+
+	if got["dir"] != "040000 tree" || got["dir/file"] != "100644 blob" || got["top"] != "100755 blob" {
+		t.Errorf("unexpected entries %v", got)
+	}
+}
+
+func runStdin(t *testing.T, dir, stdin string, args ...string) string {
+	t.Helper()
+
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Stdin = strings.NewReader(stdin)
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("git %s: %s", strings.Join(args, " "), err)
+	}
+	return string(out)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// hasMeta reports whether p contains any of the special characters recognised by [globMatch]
+func hasMeta(p string) bool {
+	return strings.ContainsAny(p, "*?[")
+}
+
+// globMatch reports whether name matches pattern, where each slash separated element of pattern is
+// matched with [path.Match], and an element of "**" matches any number of directories.
+func globMatch(pattern, name string) bool {
+	return matchElements(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchElements(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchElements(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(name) == 0 {
+			return false
+		}
+
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+
+		pattern, name = pattern[1:], name[1:]
+	}
+
+	return len(name) == 0
+}
+
+// pathPatternMatch reports whether pattern matches p, following the rules of .gitattributes and
+// .gitignore patterns: patterns without a slash match the name in any directory, others match the
+// whole path from the root.
+func pathPatternMatch(pattern, p string) bool {
+	if !strings.Contains(pattern, "/") {
+		ok, _ := path.Match(pattern, path.Base(p))
+		return ok
+	}
+
+	return globMatch(strings.TrimPrefix(pattern, "/"), p)
+}
+
+// excluded reports whether any of patterns matches p or one of the directories containing it, see
+// [pathPatternMatch]
+func excluded(patterns []string, p string) bool {
+	for _, pattern := range patterns {
+		for dir := p; dir != "." && dir != "/"; dir = path.Dir(dir) {
+			if pathPatternMatch(strings.TrimSuffix(pattern, "/"), dir) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// walkFiles returns the paths of every file under dir in fsys, skipping git directories
+func walkFiles(fsys fs.FS, dir string) ([]string, error) {
+	files := []string{}
+	err := fs.WalkDir(fsys, dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			if d.Name() == ".git" {
+				return fs.SkipDir
+			}
+			return nil
+		}
+
+		files = append(files, p)
+		return nil
+	})
+
+	return files, err
+}
+
+// globFiles returns the paths of every file in fsys that matches pattern, see [globMatch]
+func globFiles(fsys fs.FS, pattern string) ([]string, error) {
+	// Only walk the part of the tree that can match
+	elems := strings.Split(pattern, "/")
+	base := "."
+	for i, e := range elems[:len(elems)-1] {
+		if hasMeta(e) {
+			break
+		}
+		base = path.Join(elems[:i+1]...)
+	}
+
+	files, err := walkFiles(fsys, base)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	matches := []string{}
+	for _, f := range files {
+		if globMatch(pattern, f) {
+			matches = append(matches, f)
+		}
+	}
+
+	return matches, nil
+}
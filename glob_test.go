@@ -0,0 +1,125 @@
+package main
+
+import (
+	"maps"
+	"slices"
+	"testing"
+	"testing/fstest"
+)
+
+func TestGlobMatch(t *testing.T) {
+	testcases := []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"dist/**/*.js", "dist/app.js", true},
+		{"dist/**/*.js", "dist/a/b/app.js", true},
+		{"dist/**/*.js", "dist/app.css", false},
+		{"dist/**/*.js", "other/dist/app.js", false},
+		{"**/*.js", "app.js", true},
+		{"**/*.js", "a/b/app.js", true},
+		{"dist/**", "dist/a/b", true},
+		{"*.js", "a/app.js", false},
+		{"dist/*.js", "dist/a/app.js", false},
+	}
+
+	for _, tc := range testcases {
+		if got := globMatch(tc.pattern, tc.name); got != tc.want {
+			t.Errorf("globMatch(%q, %q): expected %t, got %t", tc.pattern, tc.name, tc.want, got)
+		}
+	}
+}
+
+func TestExcluded(t *testing.T) {
+	patterns := []string{"*.map", "node_modules", "/dist/vendor/", "docs/**/*.tmp"}
+
+	testcases := []struct {
+		path string
+		want bool
+	}{
+		{"app.js.map", true},
+		{"dist/deep/app.js.map", true},
+		{"dist/app.js", false},
+		{"node_modules/pkg/index.js", true},
+		{"src/node_modules/pkg/index.js", true},
+		{"dist/vendor/lib.js", true},
+		{"src/dist/vendor/lib.js", false},
+		{"docs/a/b.tmp", true},
+		{"docs/b.txt", false},
+	}
+
+	for _, tc := range testcases {
+		if got := excluded(patterns, tc.path); got != tc.want {
+			t.Errorf("%s: expected %t, got %t", tc.path, tc.want, got)
+		}
+	}
+}
+
+func TestGlobFiles(t *testing.T) {
+	fsys := fstest.MapFS{
+		"dist/app.js":        {},
+		"dist/app.css":       {},
+		"dist/nested/lib.js": {},
+		"src/main.js":        {},
+		".git/config":        {},
+	}
+
+	testcases := []struct {
+		pattern string
+		want    []string
+	}{
+		{"dist/**/*.js", []string{"dist/app.js", "dist/nested/lib.js"}},
+		{"**/*.js", []string{"dist/app.js", "dist/nested/lib.js", "src/main.js"}},
+		{"*/*.css", []string{"dist/app.css"}},
+		{"missing/*.js", []string{}},
+		{"**/config", []string{}},
+	}
+
+	for _, tc := range testcases {
+		got, err := globFiles(fsys, tc.pattern)
+		requireNoError(t, err)
+
+		if !slices.Equal(got, tc.want) {
+			t.Errorf("%s: expected %q, got %q", tc.pattern, tc.want, got)
+		}
+	}
+}
+
+func TestReadFilesLiteralPaths(t *testing.T) {
+	fsys := fstest.MapFS{
+		"app/[slug]/page.tsx": {Data: []byte("page")},
+		"pages/[id].js":       {Data: []byte("id")},
+		"pages/i.js":          {Data: []byte("i")},
+	}
+
+	// Existing paths are not treated as patterns, even though [id] would match i.js
+	c := &CommitCmd{Files: []string{"app/[slug]/page.tsx", "pages/[id].js"}}
+	entries, _, err := c.readFiles(fsys)
+	requireNoError(t, err)
+
+	got := slices.Sorted(maps.Keys(entries))
+	if !slices.Equal(got, []string{"app/[slug]/page.tsx", "pages/[id].js"}) {
+		t.Fatalf("unexpected files %q", got)
+	}
+
+	// Paths that don't exist are still matched as patterns
+	c = &CommitCmd{Files: []string{"pages/[a-z].js"}}
+	entries, _, err = c.readFiles(fsys)
+	requireNoError(t, err)
+	if got := slices.Sorted(maps.Keys(entries)); !slices.Equal(got, []string{"pages/i.js"}) {
+		t.Fatalf("unexpected files %q", got)
+	}
+
+	// A deleted file whose name contains glob characters can be deleted with --force
+	c = &CommitCmd{Files: []string{"pages/[slug].js"}}
+	if _, _, err := c.readFiles(fsys); err == nil {
+		t.Fatal("expected an error without --force")
+	}
+
+	c = &CommitCmd{Files: []string{"pages/[slug].js"}, Force: true}
+	entries, _, err = c.readFiles(fsys)
+	requireNoError(t, err)
+	if content, ok := entries["pages/[slug].js"]; !ok || content != nil {
+		t.Fatalf("expected pages/[slug].js to be deleted, got %q", entries)
+	}
+}
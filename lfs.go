@@ -0,0 +1,182 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Handling of files stored in Git LFS, selected with --lfs
+const (
+	// lfsFail refuses to push Git LFS pointers
+	lfsFail = "fail"
+
+	// lfsPointers pushes Git LFS pointers as they are, for when the objects have already been
+	// uploaded with git lfs push
+	lfsPointers = "pointers"
+
+	// lfsIgnore pushes every file as it is, without looking for files stored in Git LFS
+	lfsIgnore = "ignore"
+)
+
+// lfsMaxPointerSize is the size below which git-lfs considers a blob to possibly be a pointer
+const lfsMaxPointerSize = 1024
+
+var (
+	lfsVersionRegex = regexp.MustCompile(`^version https://(git-lfs|hawser)\.github\.com/spec/v1\n`)
+	lfsOIDRegex     = regexp.MustCompile(`(?m)^oid sha256:[0-9a-f]{64}$`)
+	lfsSizeRegex    = regexp.MustCompile(`(?m)^size [0-9]+$`)
+)
+
+// isLFSPointer reports whether content is a Git LFS pointer file, as described by
+// https://github.com/git-lfs/git-lfs/blob/main/docs/spec.md
+func isLFSPointer(content []byte) bool {
+	if len(content) >= lfsMaxPointerSize {
+		return false
+	}
+
+	return lfsVersionRegex.Match(content) && lfsOIDRegex.Match(content) && lfsSizeRegex.Match(content)
+}
+
+// gitAttributes holds the .gitattributes files of a tree by the directory they are in, "." for the
+// root. The paths it is asked about are relative to base, a directory within the tree.
+type gitAttributes struct {
+	base  string
+	files map[string][]byte
+}
+
+// readAttributes returns the .gitattributes files that apply to paths, which are relative to base.
+// read returns the contents of a file in the tree, or an error wrapping fs.ErrNotExist.
+func readAttributes(read func(string) ([]byte, error), base string, paths []string) (gitAttributes, error) {
+	attrs := gitAttributes{base: base, files: map[string][]byte{}}
+	seen := map[string]bool{}
+
+	for _, p := range paths {
+		for _, dir := range parentDirs(path.Join(base, p)) {
+			if seen[dir] {
+				continue
+			}
+			seen[dir] = true
+
+			name := path.Join(dir, ".gitattributes")
+			data, err := read(name)
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			} else if err != nil {
+				return gitAttributes{}, fmt.Errorf("read %s: %w", name, err)
+			}
+			attrs.files[dir] = data
+		}
+	}
+
+	return attrs, nil
+}
+
+// parentDirs returns the directories containing p, from the root down
+func parentDirs(p string) []string {
+	dirs := []string{"."}
+	elems := strings.Split(p, "/")
+	for i := 1; i < len(elems); i++ {
+		dirs = append(dirs, path.Join(elems[:i]...))
+	}
+	return dirs
+}
+
+// lfsTracked reports whether attrs sets filter=lfs for p. As in git, patterns in a .gitattributes
+// file are relative to its directory, files in deeper directories take precedence, and the last
+// matching line that mentions the filter attribute wins.
+func lfsTracked(attrs gitAttributes, p string) bool {
+	p = path.Join(attrs.base, p)
+
+	tracked := false
+	for _, dir := range parentDirs(p) {
+		data, ok := attrs.files[dir]
+		if !ok {
+			continue
+		}
+
+		rel := p
+		if dir != "." {
+			rel = strings.TrimPrefix(p, dir+"/")
+		}
+
+		for _, ln := range strings.Split(string(data), "\n") {
+			fields := strings.Fields(ln)
+			if len(fields) < 2 || strings.HasPrefix(fields[0], "#") || strings.HasPrefix(fields[0], "[attr]") {
+				continue
+			}
+
+			if !pathPatternMatch(fields[0], rel) {
+				continue
+			}
+
+			for _, a := range fields[1:] {
+				if a == "filter=lfs" {
+					tracked = true
+				} else if a == "-filter" || a == "!filter" || strings.HasPrefix(a, "filter=") {
+					tracked = false
+				}
+			}
+		}
+	}
+
+	return tracked
+}
+
+// lfsFiles returns the sorted paths in change whose content is a Git LFS pointer, and the paths
+// that attrs stores in LFS but which hold regular content, such as a checked out LFS file read from
+// disk. Like git-lfs, empty files are never stored in LFS.
+func lfsFiles(change Change, attrs gitAttributes) (pointers, unfiltered []string) {
+	for _, p := range slices.Sorted(maps.Keys(change.entries)) {
+		content := change.entries[p]
+		if content == nil {
+			continue
+		}
+
+		if isLFSPointer(content) {
+			pointers = append(pointers, p)
+		} else if len(content) > 0 && lfsTracked(attrs, p) {
+			unfiltered = append(unfiltered, p)
+		}
+	}
+
+	return pointers, unfiltered
+}
+
+// checkLFS looks for files stored in Git LFS in changes. Pushing a pointer leaves the remote with
+// a pointer to an object that may never have been uploaded, so pointers are an error unless mode is
+// lfsPointers. Files that attrs stores in LFS but which hold regular content would be committed as
+// regular files, which is an error unless mode is lfsIgnore, which skips every check.
+func checkLFS(mode string, attrs gitAttributes, changes []Change) error {
+	if mode == lfsIgnore {
+		return nil
+	}
+
+	for _, c := range changes {
+		pointers, unfiltered := lfsFiles(c, attrs)
+
+		if len(unfiltered) > 0 {
+			return fmt.Errorf("commit %s contains files stored in Git LFS that would be pushed as regular files: %s "+
+				"(use --lfs=ignore to push them anyway)", c.hash, strings.Join(unfiltered, ", "))
+		}
+
+		if len(pointers) == 0 {
+			continue
+		}
+
+		if mode != lfsPointers {
+			return fmt.Errorf("commit %s contains Git LFS pointers, whose objects would not be uploaded: %s "+
+				"(upload them with 'git lfs push' and use --lfs=pointers to push the pointers)",
+				c.hash, strings.Join(pointers, ", "))
+		}
+
+		log("Commit %s contains %d Git LFS pointers, their objects must already be uploaded.\n", c.hash, len(pointers))
+	}
+
+	return nil
+}
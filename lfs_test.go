@@ -0,0 +1,131 @@
+package main
+
+import (
+	"io"
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+const testLFSPointer = `version https://git-lfs.github.com/spec/v1
+oid sha256:4d7a214614ab2935c943f9e0ff69d22eadbb8f32b1258daaa5e2ca24d17e2393
+size 12345
+`
+
+func TestIsLFSPointer(t *testing.T) {
+	testcases := []struct {
+		name    string
+		content string
+		want    bool
+	}{
+		{"pointer", testLFSPointer, true},
+		{"with extension", strings.Replace(testLFSPointer, "oid", "ext-0-foo sha256:abc\noid", 1), true},
+		{"missing size", "version https://git-lfs.github.com/spec/v1\noid sha256:" + strings.Repeat("a", 64) + "\n", false},
+		{"short oid", "version https://git-lfs.github.com/spec/v1\noid sha256:abc\nsize 1\n", false},
+		{"version not first", "size 1\n" + testLFSPointer, false},
+		{"regular file", "hello world\n", false},
+		{"too large", testLFSPointer + strings.Repeat("x", lfsMaxPointerSize), false},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := isLFSPointer([]byte(tc.content)); got != tc.want {
+				t.Fatalf("expected %t, got %t", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestLFSTracked(t *testing.T) {
+	attrs := gitAttributes{base: ".", files: map[string][]byte{
+		".": []byte(`# comment
+*.psd filter=lfs diff=lfs merge=lfs -text
+/assets/** filter=lfs
+assets/small/* -filter
+docs/*.bin filter=lfs
+`),
+		// Patterns are relative to the directory, and override the files above it
+		"vendor": []byte("/*.psd -filter\n*.zip filter=lfs\n"),
+	}}
+
+	testcases := []struct {
+		path string
+		want bool
+	}{
+		{"image.psd", true},
+		{"deep/dir/image.psd", true},
+		{"image.png", false},
+		{"assets/a.png", true},
+		{"assets/deep/a.png", true},
+		{"assets/small/a.png", false},
+		{"other/assets/a.png", false},
+		{"docs/a.bin", true},
+		{"docs/deep/a.bin", false},
+		{"vendor/image.psd", false},
+		{"vendor/deep/image.psd", true},
+		{"vendor/deep/a.zip", true},
+		{"a.zip", false},
+	}
+
+	for _, tc := range testcases {
+		if got := lfsTracked(attrs, tc.path); got != tc.want {
+			t.Errorf("%s: expected %t, got %t", tc.path, tc.want, got)
+		}
+	}
+
+	// Paths relative to a directory below the root
+	attrs.base = "vendor"
+	if !lfsTracked(attrs, "deep/a.zip") || lfsTracked(attrs, "image.psd") {
+		t.Error("expected paths to be relative to the base directory")
+	}
+}
+
+func TestReadAttributes(t *testing.T) {
+	fsys := fstest.MapFS{
+		".gitattributes":       {Data: []byte("root")},
+		"a/.gitattributes":     {Data: []byte("a")},
+		"a/b/c/.gitattributes": {Data: []byte("c")},
+		"other/.gitattributes": {Data: []byte("other")},
+	}
+
+	read := func(p string) ([]byte, error) { return fs.ReadFile(fsys, p) }
+	attrs, err := readAttributes(read, "a", []string{"b/c/file", "b/file"})
+	requireNoError(t, err)
+
+	if attrs.base != "a" || len(attrs.files) != 3 ||
+		string(attrs.files["."]) != "root" || string(attrs.files["a"]) != "a" || string(attrs.files["a/b/c"]) != "c" {
+		t.Fatalf("unexpected attributes: %q", attrs.files)
+	}
+}
+
+func TestCheckLFS(t *testing.T) {
+	logwriter = io.Discard
+
+	change := Change{
+		hash: "1234",
+		entries: map[string][]byte{
+			"b.psd":    []byte(testLFSPointer),
+			"a.psd":    []byte(testLFSPointer),
+			"main.go":  []byte("package main"),
+			"gone.psd": nil,
+		},
+	}
+
+	err := checkLFS(lfsFail, gitAttributes{}, []Change{change})
+	if err == nil || !strings.Contains(err.Error(), "a.psd, b.psd") {
+		t.Fatalf("expected an error listing the pointers, got %v", err)
+	}
+
+	requireNoError(t, checkLFS(lfsPointers, gitAttributes{}, []Change{change}))
+
+	// Real content in a path stored in LFS is refused unless the checks are ignored
+	attrs := gitAttributes{base: ".", files: map[string][]byte{".": []byte("*.psd filter=lfs\n")}}
+	change.entries["c.psd"] = []byte("not a pointer")
+	err = checkLFS(lfsPointers, attrs, []Change{change})
+	if err == nil || !strings.Contains(err.Error(), "c.psd") || strings.Contains(err.Error(), "a.psd") {
+		t.Fatalf("expected an error listing only c.psd, got %v", err)
+	}
+
+	requireNoError(t, checkLFS(lfsIgnore, attrs, []Change{change}))
+}
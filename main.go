@@ -1,15 +1,17 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"net/url"
 	"os"
 	"strings"
 
 	"github.com/alecthomas/kong"
 )
 
-var logwriter io.Writer
+var logwriter, outwriter io.Writer
 
 func log(f string, args ...any) {
 	fmt.Fprintf(logwriter, f, args...)
@@ -40,6 +42,60 @@ func (f targetFlag) Repository() string {
 	return repo
 }
 
+type apiURLFlag string
+
+func (f *apiURLFlag) Decode(ctx *kong.DecodeContext) error {
+	if err := ctx.Scan.PopValueInto("string", &f); err != nil {
+		return err
+	}
+
+	u, err := url.Parse(string(*f))
+	if err != nil {
+		return err
+	}
+
+	if (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return fmt.Errorf("must be an absolute http(s) URL, such as https://github.example.com/api/v3")
+	}
+
+	*f = apiURLFlag(strings.TrimSuffix(string(*f), "/"))
+	return nil
+}
+
+// REST returns the base URL for REST API requests
+func (f apiURLFlag) REST() string {
+	return string(f)
+}
+
+// GraphQL returns the GraphQL endpoint. On GitHub Enterprise Server the REST API lives under
+// /api/v3 while GraphQL lives under /api/graphql.
+func (f apiURLFlag) GraphQL() string {
+	if base, ok := strings.CutSuffix(string(f), "/api/v3"); ok {
+		return base + "/api/graphql"
+	}
+
+	return string(f) + "/graphql"
+}
+
+// Web returns the base URL for browsing repositories, used for links in the output
+func (f apiURLFlag) Web() string {
+	if base, ok := strings.CutSuffix(string(f), "/api/v3"); ok {
+		return base
+	}
+
+	u, err := url.Parse(string(f))
+	if err != nil {
+		return string(f)
+	}
+
+	// api.github.com -> github.com, and likewise for GHE.com subdomains
+	if host, ok := strings.CutPrefix(u.Host, "api."); ok {
+		return fmt.Sprintf("%s://%s", u.Scheme, host)
+	}
+
+	return string(f)
+}
+
 // flags that are shared among commands that interact with the remote
 type remoteFlags struct {
 	Target       targetFlag `name:"target" short:"T" required:"" help:"Target repository in owner/repo format."`
@@ -47,6 +103,30 @@ type remoteFlags struct {
 	HeadSha      string     `name:"head-sha" help:"Expected commit sha of the remote branch, or the commit sha to branch from."`
 	CreateBranch bool       `name:"create-branch" help:"Create the remote branch, requires --head-sha to be set."`
 	DryRun       bool       `name:"dry-run" help:"Perform everything except the final remote writes to GitHub."`
+	Backend      string     `name:"backend" enum:"graphql,rest,auto" default:"graphql" help:"How to create commits. One of 'graphql' (signed, drops file modes, refuses submodules), 'rest' (keeps file modes and submodules, may be unsigned) or 'auto' (graphql unless a commit contains executables, symlinks or submodules)."`
+	Output       string     `name:"output" enum:"text,json" default:"text" help:"Output format. 'text' prints only the new head commit, 'json' prints a document describing every pushed commit."`
+	Oversized    string     `name:"oversized" enum:"ignore,fail,split" default:"ignore" help:"What to do with commits estimated to be larger than --max-payload-size. One of 'ignore', 'fail' (report the largest files) or 'split' (push as several commits)."`
+	MaxPayload   int        `name:"max-payload-size" default:"41943040" help:"Maximum estimated size in bytes of a single commit request, used with --oversized."`
+	LFS          string     `name:"lfs" enum:"fail,pointers,ignore" default:"fail" help:"What to do with files stored in Git LFS. One of 'fail' (report the affected paths), 'pointers' (push the pointer files, for when the objects were already uploaded with git lfs push) or 'ignore' (push every file as it is, without checking)."`
+	Rebase       bool       `name:"rebase" help:"If the remote branch moves while pushing, retry on top of the new head when the new remote commits touch none of the files being pushed."`
+	Prefix       string     `name:"prefix" help:"Directory on the remote to place every file under, such as docs/api."`
+	StripPrefix  string     `name:"strip-prefix" help:"Directory to remove from the start of every local path. Changes to files outside of it are an error."`
+	APIURL       apiURLFlag `name:"api-url" default:"https://api.github.com" env:"HEADLESS_API_URL,GITHUB_API_URL" help:"Base URL of the GitHub REST API. Set this to https://HOSTNAME/api/v3 for GitHub Enterprise Server."`
+
+	SkipUnchanged bool `name:"skip-unchanged" default:"true" negatable:"" help:"Leave out files that already match the remote branch, and commits left without any files. On by default, which needs one extra API request."`
+	NoopExitCode  int  `name:"noop-exit-code" default:"0" help:"Exit status to use when nothing is pushed because every change already matches the remote."`
+	IgnoreMissing bool `name:"ignore-missing-deletions" help:"Leave out deletions of files that don't exist on the remote branch, instead of failing."`
+
+	appFlags
+	messageFlags
+	pullRequestFlags
+}
+
+// exitStatus is returned by commands that succeed but want a specific exit status
+type exitStatus int
+
+func (e exitStatus) Error() string {
+	return fmt.Sprintf("exit status %d", int(e))
 }
 
 type CLI struct {
@@ -57,6 +137,7 @@ type CLI struct {
 
 func main() {
 	logwriter = os.Stderr
+	outwriter = os.Stdout
 
 	cli := CLI{}
 
@@ -65,5 +146,12 @@ func main() {
 		kong.Description("A tool to create signed commits on GitHub."),
 		kong.UsageOnError(),
 	)
-	ctx.FatalIfErrorf(ctx.Run())
+	err := ctx.Run()
+
+	var status exitStatus
+	if errors.As(err, &status) {
+		os.Exit(int(status))
+	}
+
+	ctx.FatalIfErrorf(err)
 }
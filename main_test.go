@@ -0,0 +1,70 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/alecthomas/kong"
+)
+
+func TestAPIURLFlag(t *testing.T) {
+	testcases := []struct {
+		input string
+
+		rest    string
+		graphql string
+		web     string
+	}{{
+		"https://api.github.com",
+		"https://api.github.com", "https://api.github.com/graphql", "https://github.com",
+	}, {
+		"https://github.example.com/api/v3/",
+		"https://github.example.com/api/v3", "https://github.example.com/api/graphql", "https://github.example.com",
+	}, {
+		"https://api.octo.ghe.com",
+		"https://api.octo.ghe.com", "https://api.octo.ghe.com/graphql", "https://octo.ghe.com",
+	}, {
+		"http://127.0.0.1:8080",
+		"http://127.0.0.1:8080", "http://127.0.0.1:8080/graphql", "http://127.0.0.1:8080",
+	}}
+
+	for _, tc := range testcases {
+		t.Run(tc.input, func(t *testing.T) {
+			cli := struct {
+				API apiURLFlag `name:"api-url"`
+			}{}
+
+			parser, err := kong.New(&cli)
+			requireNoError(t, err)
+
+			_, err = parser.Parse([]string{"--api-url", tc.input})
+			requireNoError(t, err)
+
+			if got := cli.API.REST(); got != tc.rest {
+				t.Errorf("wrong REST url, got=%s, want=%s", got, tc.rest)
+			}
+
+			if got := cli.API.GraphQL(); got != tc.graphql {
+				t.Errorf("wrong GraphQL url, got=%s, want=%s", got, tc.graphql)
+			}
+
+			if got := cli.API.Web(); got != tc.web {
+				t.Errorf("wrong web url, got=%s, want=%s", got, tc.web)
+			}
+		})
+	}
+}
+
+func TestAPIURLFlagInvalid(t *testing.T) {
+	for _, input := range []string{"api.github.com", "ftp://github.example.com", "/api/v3"} {
+		cli := struct {
+			API apiURLFlag `name:"api-url"`
+		}{}
+
+		parser, err := kong.New(&cli)
+		requireNoError(t, err)
+
+		if _, err := parser.Parse([]string{"--api-url", input}); err == nil {
+			t.Errorf("expected %q to be rejected", input)
+		}
+	}
+}
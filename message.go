@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// flags used to change the messages of pushed commits
+type messageFlags struct {
+	Trailers        []string `name:"trailer" sep:"none" help:"Trailer to add to every commit message, as key=value. May be repeated."`
+	MessageTemplate string   `name:"message-template" help:"Template for every commit message, in Go text/template syntax. The fields .Message, .Headline, .Body, .Hash, .ShortHash, .Author, .Branch, .Repository and .JobURL are available."`
+}
+
+// messageData holds the fields available to --message-template
+type messageData struct {
+	// Message is the original message, split into Headline and Body
+	Message, Headline, Body string
+
+	// Hash is the local commit hash, empty for the commit command
+	Hash, ShortHash string
+
+	Author string
+
+	// Branch and Repository (owner/repo) are the push target
+	Branch, Repository string
+
+	// JobURL links to the CI job running commit-headless, if known, see [ciJobURL]
+	JobURL string
+}
+
+// ciJobURL returns a link to the CI job running commit-headless. HEADLESS_JOB_URL is used if set,
+// otherwise the URL is built from the environment of GitHub Actions or GitLab CI.
+func ciJobURL(getenv func(string) string) string {
+	if u := getenv("HEADLESS_JOB_URL"); u != "" {
+		return u
+	}
+
+	if getenv("GITHUB_ACTIONS") == "true" && getenv("GITHUB_RUN_ID") != "" {
+		return fmt.Sprintf("%s/%s/actions/runs/%s", getenv("GITHUB_SERVER_URL"), getenv("GITHUB_REPOSITORY"), getenv("GITHUB_RUN_ID"))
+	}
+
+	return getenv("CI_JOB_URL")
+}
+
+// parseTrailer turns a key=value trailer flag into a "Key: value" trailer line
+func parseTrailer(s string) (string, error) {
+	key, value, ok := strings.Cut(s, "=")
+	key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+	if !ok || key == "" || value == "" || strings.ContainsAny(key, " \t:") {
+		return "", fmt.Errorf("invalid trailer %q, must be of the form key=value", s)
+	}
+
+	return key + ": " + value, nil
+}
+
+// applyMessageFlags rewrites the messages of changes according to flags, rendering the message
+// template if there is one and adding the trailers. branch and repository are the push target.
+func applyMessageFlags(flags messageFlags, branch, repository string, getenv func(string) string, changes []Change) error {
+	trailers := []string{}
+	for _, t := range flags.Trailers {
+		line, err := parseTrailer(t)
+		if err != nil {
+			return err
+		}
+		trailers = append(trailers, line)
+	}
+
+	var tmpl *template.Template
+	if flags.MessageTemplate != "" {
+		var err error
+		tmpl, err = template.New("message").Option("missingkey=error").Parse(flags.MessageTemplate)
+		if err != nil {
+			return fmt.Errorf("parse message template: %w", err)
+		}
+	}
+
+	jobURL := ciJobURL(getenv)
+
+	for i, c := range changes {
+		if tmpl != nil {
+			headline, body := c.splitMessage()
+			data := messageData{
+				Message:    c.message,
+				Headline:   headline,
+				Body:       strings.TrimSpace(body),
+				Author:     c.author,
+				Branch:     branch,
+				Repository: repository,
+				JobURL:     jobURL,
+			}
+
+			// The commit command has no local commit
+			if strings.Trim(c.hash, "0") != "" {
+				data.Hash, data.ShortHash = c.hash, c.hash[:min(len(c.hash), 7)]
+			}
+
+			sb := &strings.Builder{}
+			if err := tmpl.Execute(sb, data); err != nil {
+				return fmt.Errorf("render message for commit %s: %w", c.hash, err)
+			}
+
+			message := strings.TrimSpace(sb.String())
+			if message == "" {
+				return fmt.Errorf("message template produced an empty message for commit %s", c.hash)
+			}
+			changes[i].message = message
+		}
+
+		changes[i].trailers = append(changes[i].trailers, trailers...)
+	}
+
+	return nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCIJobURL(t *testing.T) {
+	testcases := []struct {
+		name string
+		env  map[string]string
+		want string
+	}{
+		{"none", nil, ""},
+		{"override", map[string]string{"HEADLESS_JOB_URL": "https://ci/job/1", "CI_JOB_URL": "https://gitlab/job"}, "https://ci/job/1"},
+		{"github actions", map[string]string{
+			"GITHUB_ACTIONS":    "true",
+			"GITHUB_SERVER_URL": "https://github.com",
+			"GITHUB_REPOSITORY": "owner/repo",
+			"GITHUB_RUN_ID":     "42",
+		}, "https://github.com/owner/repo/actions/runs/42"},
+		{"gitlab", map[string]string{"CI_JOB_URL": "https://gitlab/job"}, "https://gitlab/job"},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			getenv := func(k string) string { return tc.env[k] }
+			if got := ciJobURL(getenv); got != tc.want {
+				t.Fatalf("expected %q, got %q", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestParseTrailer(t *testing.T) {
+	got, err := parseTrailer("Reviewed-by = Jane <jane@example.com>")
+	requireNoError(t, err)
+	if got != "Reviewed-by: Jane <jane@example.com>" {
+		t.Fatalf("unexpected trailer %q", got)
+	}
+
+	got, err = parseTrailer("Link=https://example.com/?a=b")
+	requireNoError(t, err)
+	if got != "Link: https://example.com/?a=b" {
+		t.Fatalf("unexpected trailer %q", got)
+	}
+
+	for _, bad := range []string{"no-value", "=value", "key=", "two words=value", "key:=value"} {
+		if _, err := parseTrailer(bad); err == nil {
+			t.Errorf("expected an error for %q", bad)
+		}
+	}
+}
+
+func TestApplyMessageFlags(t *testing.T) {
+	getenv := func(k string) string {
+		if k == "HEADLESS_JOB_URL" {
+			return "https://ci/job/1"
+		}
+		return ""
+	}
+
+	flags := messageFlags{
+		Trailers:        []string{"Job=https://ci/job/1"},
+		MessageTemplate: "[bot] {{.Headline}}\n\n{{.Body}}\n\nFrom {{.ShortHash}} by {{.Author}} for {{.Repository}}@{{.Branch}} in {{.JobURL}}",
+	}
+
+	changes := []Change{{
+		hash:     "0123456789abcdef0123456789abcdef01234567",
+		author:   "Jane <jane@example.com>",
+		message:  "subject\n\nbody",
+		trailers: []string{"Signed-off-by: jane"},
+	}}
+
+	requireNoError(t, applyMessageFlags(flags, "main", "owner/repo", getenv, changes))
+
+	want := "[bot] subject\n\nbody\n\nFrom 0123456 by Jane <jane@example.com> for owner/repo@main in https://ci/job/1"
+	if changes[0].message != want {
+		t.Fatalf("unexpected message %q", changes[0].message)
+	}
+
+	if strings.Join(changes[0].trailers, "\n") != "Signed-off-by: jane\nJob: https://ci/job/1" {
+		t.Fatalf("unexpected trailers %q", changes[0].trailers)
+	}
+
+	// The commit command has no local hash
+	changes = []Change{{hash: strings.Repeat("0", 40), message: "subject"}}
+	requireNoError(t, applyMessageFlags(messageFlags{MessageTemplate: "{{.Headline}}{{if .Hash}} ({{.Hash}}){{end}}"}, "main", "owner/repo", getenv, changes))
+	if changes[0].message != "subject" {
+		t.Fatalf("unexpected message %q", changes[0].message)
+	}
+
+	for _, tmpl := range []string{"{{.Unknown}}", "{{.Headline", "{{/* empty */}}"} {
+		changes = []Change{{hash: "1234", message: "subject"}}
+		if err := applyMessageFlags(messageFlags{MessageTemplate: tmpl}, "main", "owner/repo", getenv, changes); err == nil {
+			t.Errorf("expected an error for template %q", tmpl)
+		}
+	}
+}
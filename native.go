@@ -0,0 +1,659 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// nativeRepository reads commits from the repository at path without running git, by reading the
+// refs and object database directly. It supports a subset of git's revision syntax: full or
+// abbreviated hashes, reference names, the ~ and ^ suffixes and A..B ranges.
+type nativeRepository struct {
+	path string
+
+	// gitDir holds HEAD, and commonDir the refs and objects. They differ for linked worktrees.
+	gitDir, commonDir string
+
+	// db is opened on demand, see [nativeRepository.objects]
+	db *objectDB
+
+	// commits caches parsed commits, which are read several times while walking history
+	commits map[string]nativeCommit
+
+	// flattenMerges allows merge commits, see [Repository]
+	flattenMerges bool
+}
+
+// nativeCommit is the parsed header of a commit object
+type nativeCommit struct {
+	tree    string
+	parents []string
+
+	// time is the committer timestamp, used to walk history in roughly the same order as git
+	time int64
+}
+
+func (r *nativeRepository) resolveCommits(revs ...string) ([]string, error) {
+	commits := []string{}
+	for _, rev := range revs {
+		from, to, isRange := strings.Cut(rev, "..")
+		if !isRange {
+			h, err := r.resolve(rev)
+			if err != nil {
+				return nil, fmt.Errorf("resolve %q: %w", rev, err)
+			}
+
+			commits = append(commits, h)
+			continue
+		}
+
+		if strings.HasPrefix(to, ".") {
+			return nil, fmt.Errorf("resolve range %q: symmetric differences are not supported", rev)
+		}
+
+		// Either side of a range defaults to HEAD
+		from, to = cmp.Or(from, "HEAD"), cmp.Or(to, "HEAD")
+
+		exclude, err := r.resolve(from)
+		if err != nil {
+			return nil, fmt.Errorf("resolve range %q: %w", rev, err)
+		}
+
+		include, err := r.resolve(to)
+		if err != nil {
+			return nil, fmt.Errorf("resolve range %q: %w", rev, err)
+		}
+
+		found, err := r.revList(include, exclude)
+		if err != nil {
+			return nil, fmt.Errorf("resolve range %q: %w", rev, err)
+		}
+
+		if len(found) == 0 {
+			return nil, fmt.Errorf("range %q contains no commits", rev)
+		}
+
+		for _, h := range found {
+			c, err := r.commit(h)
+			if err != nil {
+				return nil, err
+			}
+
+			if len(c.parents) > 1 && !r.flattenMerges {
+				return nil, fmt.Errorf("range %q includes a merge commit (%s), not continuing (see --flatten-merges)", rev, h)
+			}
+		}
+
+		commits = append(commits, found...)
+	}
+
+	return commits, nil
+}
+
+// Returns a Change for each supplied commit, ordered by parentage, see [Repository.Changes]
+func (r *nativeRepository) Changes(commits ...string) ([]Change, error) {
+	db, err := r.objects()
+	if err != nil {
+		return nil, err
+	}
+
+	return buildChanges(db, r.diffTrees, r.flattenMerges, commits...)
+}
+
+// attributes returns the .gitattributes files in commit that apply to paths, see
+// [Repository.attributes]
+func (r *nativeRepository) attributes(commit string, paths []string) (gitAttributes, error) {
+	db, err := r.objects()
+	if err != nil {
+		return gitAttributes{}, err
+	}
+
+	return readAttributes(func(p string) ([]byte, error) { return commitFile(db, commit, p) }, ".", paths)
+}
+
+func (r *nativeRepository) Close() error {
+	if r.db == nil {
+		return nil
+	}
+
+	err := r.db.Close()
+	r.db = nil
+	return err
+}
+
+// objects returns the object database of the repository, locating the repository if needed
+func (r *nativeRepository) objects() (*objectDB, error) {
+	if r.db != nil {
+		return r.db, nil
+	}
+
+	if err := r.locate(); err != nil {
+		return nil, err
+	}
+
+	db, err := openObjectDB(filepath.Join(r.commonDir, "objects"))
+	if err != nil {
+		return nil, fmt.Errorf("open object database: %w", err)
+	}
+
+	r.db = db
+	return db, nil
+}
+
+// locate finds the git directory for path, searching parent directories like git does
+func (r *nativeRepository) locate() error {
+	dir, err := filepath.Abs(r.path)
+	if err != nil {
+		return err
+	}
+
+	for {
+		dotgit := filepath.Join(dir, ".git")
+		if fi, err := os.Stat(dotgit); err == nil {
+			if fi.IsDir() {
+				r.gitDir = dotgit
+				break
+			}
+
+			// Linked worktrees and submodules have a file pointing at the real git directory
+			data, err := os.ReadFile(dotgit)
+			if err != nil {
+				return err
+			}
+
+			target, ok := strings.CutPrefix(strings.TrimSpace(string(data)), "gitdir: ")
+			if !ok {
+				return fmt.Errorf("%s is not a git directory reference", dotgit)
+			}
+			if !filepath.IsAbs(target) {
+				target = filepath.Join(dir, target)
+			}
+
+			r.gitDir = filepath.Clean(target)
+			break
+		}
+
+		// Bare repositories have no .git, just the contents of one
+		if isGitDir(dir) {
+			r.gitDir = dir
+			break
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return fmt.Errorf("%s is not in a git repository", r.path)
+		}
+		dir = parent
+	}
+
+	r.commonDir = r.gitDir
+	if data, err := os.ReadFile(filepath.Join(r.gitDir, "commondir")); err == nil {
+		common := strings.TrimSpace(string(data))
+		if !filepath.IsAbs(common) {
+			common = filepath.Join(r.gitDir, common)
+		}
+		r.commonDir = filepath.Clean(common)
+	}
+
+	return nil
+}
+
+func isGitDir(dir string) bool {
+	for _, name := range []string{"HEAD", "objects", "refs"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// resolve returns the commit hash for a revision such as HEAD~2, v1.0^ or an abbreviated hash
+func (r *nativeRepository) resolve(rev string) (string, error) {
+	db, err := r.objects()
+	if err != nil {
+		return "", err
+	}
+
+	name, suffix := rev, ""
+	if i := strings.IndexAny(rev, "~^"); i != -1 {
+		name, suffix = rev[:i], rev[i:]
+	}
+
+	h, err := r.resolveName(db, name)
+	if err != nil {
+		return "", err
+	}
+
+	h, err = r.peel(h)
+	if err != nil {
+		return "", err
+	}
+
+	// Each suffix is ~<n> (the nth first parent), ^<n> (the nth parent) or ^{commit}, and a
+	// missing n means 1
+	for suffix != "" {
+		op := suffix[0]
+		suffix = suffix[1:]
+
+		if op == '^' && strings.HasPrefix(suffix, "{commit}") {
+			suffix = strings.TrimPrefix(suffix, "{commit}")
+			continue
+		}
+
+		digits := len(suffix) - len(strings.TrimLeft(suffix, "0123456789"))
+		n := 1
+		if digits > 0 {
+			n, _ = strconv.Atoi(suffix[:digits])
+			suffix = suffix[digits:]
+		}
+
+		if op == '^' {
+			if n == 0 {
+				continue
+			}
+
+			c, err := r.commit(h)
+			if err != nil {
+				return "", err
+			}
+			if n > len(c.parents) {
+				return "", fmt.Errorf("%s has no parent %d", h, n)
+			}
+			h = c.parents[n-1]
+			continue
+		}
+
+		for range n {
+			c, err := r.commit(h)
+			if err != nil {
+				return "", err
+			}
+			if len(c.parents) == 0 {
+				return "", fmt.Errorf("%s has no parent", h)
+			}
+			h = c.parents[0]
+		}
+	}
+
+	return h, nil
+}
+
+// resolveName returns the object named by a full hash, a reference or an abbreviated hash, in the
+// same order of preference as git
+func (r *nativeRepository) resolveName(db *objectDB, name string) (string, error) {
+	isHex := hashRegex.MatchString(name)
+	if isHex && len(name) == 40 {
+		return name, nil
+	}
+
+	for _, candidate := range []string{name, "refs/" + name, "refs/tags/" + name, "refs/heads/" + name, "refs/remotes/" + name, "refs/remotes/" + name + "/HEAD"} {
+		h, ok, err := r.readRef(candidate, 0)
+		if err != nil {
+			return "", err
+		} else if ok {
+			return h, nil
+		}
+	}
+
+	if isHex {
+		return db.expand(name)
+	}
+
+	return "", errors.New("not a commit")
+}
+
+// readRef returns the hash a reference points to, following symbolic references
+func (r *nativeRepository) readRef(name string, depth int) (string, bool, error) {
+	if depth > 5 {
+		return "", false, fmt.Errorf("too many levels of symbolic references at %s", name)
+	}
+
+	if name == "" || strings.Contains(name, "..") || filepath.IsAbs(name) {
+		return "", false, nil
+	}
+
+	// Pseudo refs such as HEAD belong to the worktree, everything else is shared
+	dir := r.commonDir
+	if !strings.HasPrefix(name, "refs/") {
+		dir = r.gitDir
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+	if err == nil {
+		value := strings.TrimSpace(string(data))
+		if target, ok := strings.CutPrefix(value, "ref: "); ok {
+			return r.readRef(target, depth+1)
+		}
+		if hashRegex.MatchString(value) && len(value) == 40 {
+			return value, true, nil
+		}
+	}
+
+	return r.packedRef(name)
+}
+
+// packedRef looks up a reference in the packed-refs file
+func (r *nativeRepository) packedRef(name string) (string, bool, error) {
+	data, err := os.ReadFile(filepath.Join(r.commonDir, "packed-refs"))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", false, nil
+	} else if err != nil {
+		return "", false, err
+	}
+
+	// Lines are "<hash> <name>", with comments and peeled tags ("^<hash>") in between
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		h, ref, ok := strings.Cut(scanner.Text(), " ")
+		if ok && ref == name {
+			return h, true, nil
+		}
+	}
+
+	return "", false, scanner.Err()
+}
+
+// peel follows annotated tags until reaching a commit
+func (r *nativeRepository) peel(h string) (string, error) {
+	for range 10 {
+		t, data, err := r.db.object(h)
+		if err != nil {
+			return "", err
+		}
+
+		switch t {
+		case "commit":
+			return h, nil
+		case "tag":
+			target, _, _ := strings.Cut(string(data), "\n")
+			h = strings.TrimPrefix(target, "object ")
+		default:
+			return "", fmt.Errorf("%s is a %s, not a commit", h, t)
+		}
+	}
+
+	return "", fmt.Errorf("too many nested tags at %s", h)
+}
+
+// commit reads and parses the header of a commit
+func (r *nativeRepository) commit(h string) (nativeCommit, error) {
+	if c, ok := r.commits[h]; ok {
+		return c, nil
+	}
+
+	data, err := r.db.read(h, "commit")
+	if err != nil {
+		return nativeCommit{}, err
+	}
+
+	c := nativeCommit{}
+	for _, ln := range strings.Split(string(data), "\n") {
+		if ln == "" {
+			break
+		}
+
+		key, value, _ := strings.Cut(ln, " ")
+		switch key {
+		case "tree":
+			c.tree = value
+		case "parent":
+			c.parents = append(c.parents, value)
+		case "committer":
+			// committer is Name <email> timestamp timezone
+			fs := strings.Fields(value)
+			if len(fs) >= 2 {
+				c.time, _ = strconv.ParseInt(fs[len(fs)-2], 10, 64)
+			}
+		}
+	}
+
+	if r.commits == nil {
+		r.commits = map[string]nativeCommit{}
+	}
+	r.commits[h] = c
+
+	return c, nil
+}
+
+// revList returns the commits reachable from include but not from exclude, oldest first, like
+// git rev-list --reverse exclude..include.
+// Like git, history is walked newest first by commit time, and the walk stops once every commit
+// left to visit is reachable from exclude.
+func (r *nativeRepository) revList(include, exclude string) ([]string, error) {
+	uninteresting := map[string]bool{exclude: true}
+	seen := map[string]bool{include: true, exclude: true}
+	times := map[string]int64{}
+	queue := []string{include, exclude}
+	found := []string{}
+
+	for slices.ContainsFunc(queue, func(h string) bool { return !uninteresting[h] }) {
+		// Pop the newest commit
+		newest := 0
+		for i, h := range queue {
+			if _, ok := times[h]; !ok {
+				c, err := r.commit(h)
+				if err != nil {
+					return nil, err
+				}
+				times[h] = c.time
+			}
+			if times[h] > times[queue[newest]] {
+				newest = i
+			}
+		}
+
+		h := queue[newest]
+		queue = slices.Delete(queue, newest, newest+1)
+
+		c, err := r.commit(h)
+		if err != nil {
+			return nil, err
+		}
+
+		parents := c.parents
+		if uninteresting[h] {
+			for _, p := range parents {
+				uninteresting[p] = true
+			}
+		} else {
+			found = append(found, h)
+			if r.flattenMerges && len(parents) > 1 {
+				// See [Repository.resolveCommits], flattened merges already contain the merged
+				// branch so it isn't walked
+				parents = parents[:1]
+			}
+		}
+
+		for _, p := range parents {
+			if !seen[p] {
+				seen[p] = true
+				queue = append(queue, p)
+			}
+		}
+	}
+
+	// Commits may have been found to be reachable from exclude after they were visited
+	found = slices.DeleteFunc(found, func(h string) bool { return uninteresting[h] })
+	slices.Reverse(found)
+	return found, nil
+}
+
+// diffTrees returns the changed files of each commit relative to the matching base, or its first
+// parent, in the same form as git diff-tree -r. See [Repository.diffTrees].
+func (r *nativeRepository) diffTrees(commits, bases []string) ([][]diffEntry, error) {
+	diffs := make([][]diffEntry, len(commits))
+	for i, h := range commits {
+		c, err := r.commit(h)
+		if err != nil {
+			return nil, err
+		}
+
+		base := bases[i]
+		if base == "" && len(c.parents) > 0 {
+			base = c.parents[0]
+		}
+
+		// Like git diff-tree without --root, root commits have no changes
+		if base == "" {
+			continue
+		}
+
+		b, err := r.commit(base)
+		if err != nil {
+			return nil, err
+		}
+
+		diffs[i] = []diffEntry{}
+		if err := r.diffTree(b.tree, c.tree, "", &diffs[i]); err != nil {
+			return nil, fmt.Errorf("diff %s: %w", h, err)
+		}
+	}
+
+	return diffs, nil
+}
+
+const (
+	modeNone = "000000"
+	modeTree = "040000"
+)
+
+// nativeTreeEntry is an entry of a tree object
+type nativeTreeEntry struct {
+	mode, sha string
+}
+
+// diffTree appends the differences between trees a and b (either of which may be empty) to out,
+// recursing into subtrees and naming paths relative to prefix
+func (r *nativeRepository) diffTree(a, b, prefix string, out *[]diffEntry) error {
+	if a == b {
+		return nil
+	}
+
+	before, err := r.tree(a)
+	if err != nil {
+		return err
+	}
+
+	after, err := r.tree(b)
+	if err != nil {
+		return err
+	}
+
+	names := slices.Sorted(func(yield func(string) bool) {
+		for n := range before {
+			if !yield(n) {
+				return
+			}
+		}
+		for n := range after {
+			if _, ok := before[n]; !ok && !yield(n) {
+				return
+			}
+		}
+	})
+
+	for _, name := range names {
+		path := prefix + name
+		src, dst := before[name], after[name]
+
+		srcTree, dstTree := src.mode == modeTree, dst.mode == modeTree
+		if src == dst {
+			continue
+		}
+
+		// Subtrees are compared recursively, and a file replaced by a directory (or the reverse)
+		// is a deletion and additions
+		if srcTree || dstTree {
+			srcSha, dstSha := "", ""
+			if srcTree {
+				srcSha = src.sha
+			} else if src.mode != "" {
+				*out = append(*out, diffEntry{srcMode: src.mode, dstMode: modeNone, srcSha: src.sha, dstSha: zeroSha, status: "D", path: path})
+			}
+
+			if dstTree {
+				dstSha = dst.sha
+			}
+
+			if err := r.diffTree(srcSha, dstSha, path+"/", out); err != nil {
+				return err
+			}
+
+			if !dstTree && dst.mode != "" {
+				*out = append(*out, diffEntry{srcMode: modeNone, dstMode: dst.mode, srcSha: zeroSha, dstSha: dst.sha, status: "A", path: path})
+			}
+			continue
+		}
+
+		e := diffEntry{srcMode: cmp.Or(src.mode, modeNone), dstMode: cmp.Or(dst.mode, modeNone), srcSha: cmp.Or(src.sha, zeroSha), dstSha: cmp.Or(dst.sha, zeroSha), path: path}
+		switch {
+		case src.mode == "":
+			e.status = "A"
+		case dst.mode == "":
+			e.status = "D"
+		case fileType(src.mode) != fileType(dst.mode):
+			e.status = "T"
+		default:
+			e.status = "M"
+		}
+
+		*out = append(*out, e)
+	}
+
+	return nil
+}
+
+const zeroSha = "0000000000000000000000000000000000000000"
+
+// fileType returns the type bits of a mode, distinguishing files, symlinks and gitlinks
+func fileType(mode string) string {
+	return mode[:len(mode)-4]
+}
+
+// tree reads the entries of a tree object, by name. An empty hash is an empty tree.
+func (r *nativeRepository) tree(h string) (map[string]nativeTreeEntry, error) {
+	entries := map[string]nativeTreeEntry{}
+	if h == "" {
+		return entries, nil
+	}
+
+	data, err := r.db.read(h, "tree")
+	if err != nil {
+		return nil, err
+	}
+
+	return parseTree(h, data)
+}
+
+// parseTree returns the entries of the raw tree object h, by name
+func parseTree(h string, data []byte) (map[string]nativeTreeEntry, error) {
+	entries := map[string]nativeTreeEntry{}
+
+	// Each entry is "<mode> <name>\0" followed by the 20 byte hash
+	for len(data) > 0 {
+		sp := bytes.IndexByte(data, ' ')
+		nul := bytes.IndexByte(data, 0)
+		if sp == -1 || nul < sp || len(data) < nul+21 {
+			return nil, fmt.Errorf("tree %s is corrupt", h)
+		}
+
+		mode := string(data[:sp])
+		if len(mode) < 6 {
+			// Trees are stored without leading zeros
+			mode = strings.Repeat("0", 6-len(mode)) + mode
+		}
+
+		entries[string(data[sp+1:nul])] = nativeTreeEntry{mode: mode, sha: fmt.Sprintf("%x", data[nul+1:nul+21])}
+		data = data[nul+21:]
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// nativeTestRepo builds a repository with a mix of changes that both git readers must agree on
+func nativeTestRepo(t *testing.T) (*testRepository, string) {
+	tr := testRepo(t)
+
+	requireNoError(t, os.WriteFile(tr.path("base"), []byte("base"), 0o644))
+	tr.git("add", "-A")
+	tr.git("commit", "--message", "initial commit")
+	tr.git("branch", "-M", "main")
+	base := strings.TrimSpace(string(tr.git("rev-parse", "HEAD")))
+
+	// Similar content across commits, so that git gc stores deltas
+	content := strings.Repeat("a line of generated content\n", 200)
+	for i := range 3 {
+		requireNoError(t, os.MkdirAll(tr.path("dir", "nested"), 0o755))
+		requireNoError(t, os.WriteFile(tr.path("dir", "nested", "generated"), []byte(content+fmt.Sprint(i)), 0o644))
+		requireNoError(t, os.WriteFile(tr.path(fmt.Sprintf("file-%d", i)), []byte(fmt.Sprint(i)), 0o644))
+		tr.git("add", "-A")
+		tr.git("commit", "--message", fmt.Sprintf("commit %d\n\nwith a body", i))
+	}
+
+	// Executables, symlinks, deletions and type changes
+	requireNoError(t, os.WriteFile(tr.path("script.sh"), []byte("#!/bin/sh\n"), 0o755))
+	requireNoError(t, os.Symlink("base", tr.path("link")))
+	requireNoError(t, os.Remove(tr.path("file-0")))
+	requireNoError(t, os.Remove(tr.path("file-1")))
+	requireNoError(t, os.Symlink("base", tr.path("file-1")))
+	tr.git("add", "-A")
+	tr.git("commit", "--message", "modes")
+
+	// A file replaced by a directory and the other way around
+	requireNoError(t, os.Remove(tr.path("file-2")))
+	requireNoError(t, os.MkdirAll(tr.path("file-2"), 0o755))
+	requireNoError(t, os.WriteFile(tr.path("file-2", "inside"), []byte("inside"), 0o644))
+	requireNoError(t, os.RemoveAll(tr.path("dir")))
+	requireNoError(t, os.WriteFile(tr.path("dir"), []byte("dir"), 0o644))
+	tr.git("add", "-A")
+	tr.git("commit", "--message", "directories")
+
+	tr.git("tag", "--annotate", "--message", "a tag", "v1")
+
+	return tr, base
+}
+
+func TestNativeRepositoryMatchesGit(t *testing.T) {
+	tr, base := nativeTestRepo(t)
+
+	compare := func(t *testing.T, revs ...string) {
+		t.Helper()
+
+		exec := &Repository{path: tr.root}
+		defer exec.Close()
+
+		native := &nativeRepository{path: tr.path("file-2")}
+		defer native.Close()
+
+		want, err := exec.resolveCommits(revs...)
+		requireNoError(t, err)
+
+		got, err := native.resolveCommits(revs...)
+		requireNoError(t, err)
+
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("resolve %q: expected %q, got %q", revs, want, got)
+		}
+
+		wantChanges, err := exec.Changes(want...)
+		requireNoError(t, err)
+
+		gotChanges, err := native.Changes(got...)
+		requireNoError(t, err)
+
+		if !reflect.DeepEqual(gotChanges, wantChanges) {
+			t.Fatalf("changes %q: expected\n%+v\ngot\n%+v", revs, wantChanges, gotChanges)
+		}
+	}
+
+	revsets := [][]string{
+		{base + "..HEAD"},
+		{"main~3..main"},
+		{"HEAD~2", "HEAD^", "v1"},
+		{base[:8] + "..v1^{commit}"},
+	}
+
+	for _, revs := range revsets {
+		compare(t, revs...)
+	}
+
+	// Packed objects (with deltas) and packed refs
+	tr.git("gc", "--aggressive", "--quiet")
+	for _, revs := range revsets {
+		compare(t, revs...)
+	}
+}
+
+func TestNativeRepositoryMerges(t *testing.T) {
+	tr := testRepo(t)
+
+	requireNoError(t, os.WriteFile(tr.path("base"), []byte("base"), 0o644))
+	tr.git("add", "-A")
+	tr.git("commit", "--message", "initial commit")
+	tr.git("branch", "-M", "main")
+	base := strings.TrimSpace(string(tr.git("rev-parse", "HEAD")))
+
+	tr.git("checkout", "-b", "feature")
+	requireNoError(t, os.WriteFile(tr.path("feature"), []byte("feature"), 0o644))
+	tr.git("add", "-A")
+	tr.git("commit", "--message", "feature commit")
+
+	tr.git("checkout", "main")
+	requireNoError(t, os.WriteFile(tr.path("main"), []byte("main"), 0o644))
+	tr.git("add", "-A")
+	tr.git("commit", "--message", "main commit")
+	tr.git("merge", "--no-ff", "--message", "merge feature", "feature")
+
+	refusing := &nativeRepository{path: tr.root}
+	defer refusing.Close()
+
+	if _, err := refusing.resolveCommits(base + "..HEAD"); err == nil {
+		t.Fatal("expected merge commits in a range to be refused")
+	}
+
+	exec := &Repository{path: tr.root, flattenMerges: true}
+	defer exec.Close()
+
+	want, err := exec.resolveCommits(base + "..HEAD")
+	requireNoError(t, err)
+
+	native := &nativeRepository{path: tr.root, flattenMerges: true}
+	defer native.Close()
+
+	got, err := native.resolveCommits(base + "..HEAD")
+	requireNoError(t, err)
+
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+
+	changes, err := native.Changes(got...)
+	requireNoError(t, err)
+
+	if merge := changes[len(changes)-1]; len(merge.entries) != 1 || merge.entries["feature"] == nil {
+		t.Errorf("expected the flattened merge to add only feature, got %q", merge.entries)
+	}
+}
+
+func TestNativeRepositoryErrors(t *testing.T) {
+	tr, _ := nativeTestRepo(t)
+
+	r := &nativeRepository{path: tr.root}
+	defer r.Close()
+
+	for _, rev := range []string{"not-a-ref", "HEAD..HEAD", "main...HEAD", "HEAD^3", "0000000"} {
+		if _, err := r.resolveCommits(rev); err == nil {
+			t.Errorf("expected an error resolving %q", rev)
+		}
+	}
+
+	outside := &nativeRepository{path: t.TempDir()}
+	defer outside.Close()
+
+	if _, err := outside.resolveCommits("HEAD"); err == nil {
+		t.Error("expected an error outside of a repository")
+	}
+}
@@ -0,0 +1,519 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Object types as stored in pack files
+const (
+	packCommit   = 1
+	packTree     = 2
+	packBlob     = 3
+	packTag      = 4
+	packOfsDelta = 6
+	packRefDelta = 7
+)
+
+var packTypes = map[int]string{packCommit: "commit", packTree: "tree", packBlob: "blob", packTag: "tag"}
+
+// maxDeltaCache is the number of delta base objects kept in memory while reading pack files
+const maxDeltaCache = 256
+
+// objectDB reads objects directly from a repository's object directories, without running git.
+// Both loose objects and pack files (with version 2 indexes) are supported.
+type objectDB struct {
+	// dirs are the object directories, the repository's own followed by any alternates
+	dirs  []string
+	packs []*packFile
+}
+
+func openObjectDB(dir string) (*objectDB, error) {
+	db := &objectDB{}
+	if err := db.addDir(dir, 0); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// addDir adds an object directory, its pack files and any alternates it lists
+func (db *objectDB) addDir(dir string, depth int) error {
+	if depth > 5 {
+		return fmt.Errorf("too many nested alternates at %s", dir)
+	}
+
+	if slices.Contains(db.dirs, dir) {
+		return nil
+	}
+	db.dirs = append(db.dirs, dir)
+
+	idxs, err := filepath.Glob(filepath.Join(dir, "pack", "*.idx"))
+	if err != nil {
+		return err
+	}
+
+	for _, idx := range idxs {
+		pf, err := openPackFile(strings.TrimSuffix(idx, ".idx"))
+		if err != nil {
+			return err
+		}
+		db.packs = append(db.packs, pf)
+	}
+
+	alternates, err := os.ReadFile(filepath.Join(dir, "info", "alternates"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	for _, ln := range strings.Split(string(alternates), "\n") {
+		ln = strings.TrimSpace(ln)
+		if ln == "" || strings.HasPrefix(ln, "#") {
+			continue
+		}
+		if !filepath.IsAbs(ln) {
+			ln = filepath.Join(dir, ln)
+		}
+		if err := db.addDir(filepath.Clean(ln), depth+1); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// read returns the contents of the object with the full hash rev, which must be of type typ
+func (db *objectDB) read(rev, typ string) ([]byte, error) {
+	t, data, err := db.object(rev)
+	if err != nil {
+		return nil, err
+	} else if t != typ {
+		return nil, fmt.Errorf("%s is a %s, not a %s", rev, t, typ)
+	}
+	return data, nil
+}
+
+// object returns the type and contents of the object with the full hash rev
+func (db *objectDB) object(rev string) (string, []byte, error) {
+	id, err := hex.DecodeString(rev)
+	if err != nil || len(id) != 20 {
+		return "", nil, fmt.Errorf("invalid object name %q", rev)
+	}
+
+	for _, pf := range db.packs {
+		if off, ok := pf.find(id); ok {
+			typ, data, err := pf.readAt(db, off)
+			if err != nil {
+				return "", nil, fmt.Errorf("read %s from %s: %w", rev, filepath.Base(pf.path), err)
+			}
+			return packTypes[typ], data, nil
+		}
+	}
+
+	for _, dir := range db.dirs {
+		f, err := os.Open(filepath.Join(dir, rev[:2], rev[2:]))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return "", nil, err
+		}
+		defer f.Close()
+
+		typ, data, err := readLooseObject(f)
+		if err != nil {
+			return "", nil, fmt.Errorf("read %s: %w", rev, err)
+		}
+		return typ, data, nil
+	}
+
+	return "", nil, fmt.Errorf("%s: %w", rev, errObjectMissing)
+}
+
+// expand returns the full hash of the single object whose hash starts with prefix
+func (db *objectDB) expand(prefix string) (string, error) {
+	found := map[string]bool{}
+
+	for _, pf := range db.packs {
+		for _, h := range pf.withPrefix(prefix) {
+			found[h] = true
+		}
+	}
+
+	for _, dir := range db.dirs {
+		entries, err := os.ReadDir(filepath.Join(dir, prefix[:2]))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return "", err
+		}
+
+		for _, e := range entries {
+			if h := prefix[:2] + e.Name(); len(h) == 40 && strings.HasPrefix(h, prefix) {
+				found[h] = true
+			}
+		}
+	}
+
+	switch len(found) {
+	case 0:
+		return "", fmt.Errorf("%s: %w", prefix, errObjectMissing)
+	case 1:
+		for h := range found {
+			return h, nil
+		}
+	}
+
+	return "", fmt.Errorf("short object name %s is ambiguous", prefix)
+}
+
+func (db *objectDB) Close() error {
+	var errs []error
+	for _, pf := range db.packs {
+		errs = append(errs, pf.file.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// readLooseObject decodes a zlib compressed "<type> <size>\0<data>" loose object
+func readLooseObject(r io.Reader) (string, []byte, error) {
+	zr, err := zlib.NewReader(r)
+	if err != nil {
+		return "", nil, err
+	}
+	defer zr.Close()
+
+	br := bufio.NewReader(zr)
+	header, err := br.ReadString(0)
+	if err != nil {
+		return "", nil, fmt.Errorf("read object header: %w", err)
+	}
+
+	typ, sizeStr, _ := strings.Cut(strings.TrimSuffix(header, "\x00"), " ")
+	size, err := strconv.Atoi(sizeStr)
+	if err != nil {
+		return "", nil, fmt.Errorf("unexpected object header %q", header)
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(br, data); err != nil {
+		return "", nil, fmt.Errorf("read object data: %w", err)
+	}
+
+	return typ, data, nil
+}
+
+// packFile is a pack file and its version 2 index
+type packFile struct {
+	path string
+	file *os.File
+
+	// fanout[b] is the number of objects whose first byte is <= b
+	fanout  [256]uint32
+	names   []byte // 20 bytes per object, sorted
+	offsets []uint32
+	large   []byte // 8 bytes per large offset
+
+	// cache holds recently used delta bases, by offset
+	cache map[int64]cachedObject
+}
+
+type cachedObject struct {
+	typ  int
+	data []byte
+}
+
+func openPackFile(path string) (*packFile, error) {
+	idx, err := os.ReadFile(path + ".idx")
+	if err != nil {
+		return nil, err
+	}
+
+	if len(idx) < 8+256*4 || !bytes.Equal(idx[:4], []byte{0xff, 't', 'O', 'c'}) || binary.BigEndian.Uint32(idx[4:8]) != 2 {
+		return nil, fmt.Errorf("%s.idx: unsupported pack index format", path)
+	}
+
+	pf := &packFile{path: path, cache: map[int64]cachedObject{}}
+	for i := range pf.fanout {
+		pf.fanout[i] = binary.BigEndian.Uint32(idx[8+i*4:])
+	}
+
+	n := int(pf.fanout[255])
+	rest := idx[8+256*4:]
+
+	// Names, then CRCs, then offsets, then large offsets
+	if len(rest) < n*(20+4+4) {
+		return nil, fmt.Errorf("%s.idx: truncated", path)
+	}
+
+	pf.names = rest[:n*20]
+	rest = rest[n*(20+4):]
+
+	pf.offsets = make([]uint32, n)
+	for i := range pf.offsets {
+		pf.offsets[i] = binary.BigEndian.Uint32(rest[i*4:])
+	}
+	pf.large = rest[n*4:]
+
+	pf.file, err = os.Open(path + ".pack")
+	if err != nil {
+		return nil, err
+	}
+
+	return pf, nil
+}
+
+// find returns the offset of the object with the given id in the pack file
+func (pf *packFile) find(id []byte) (int64, bool) {
+	lo, hi := 0, int(pf.fanout[id[0]])
+	if id[0] > 0 {
+		lo = int(pf.fanout[id[0]-1])
+	}
+
+	for lo < hi {
+		mid := (lo + hi) / 2
+		switch bytes.Compare(pf.names[mid*20:mid*20+20], id) {
+		case 0:
+			return pf.offset(mid), true
+		case -1:
+			lo = mid + 1
+		default:
+			hi = mid
+		}
+	}
+
+	return 0, false
+}
+
+func (pf *packFile) offset(i int) int64 {
+	off := pf.offsets[i]
+	if off&0x80000000 == 0 {
+		return int64(off)
+	}
+
+	// The remaining bits are an index into the table of 64-bit offsets
+	j := int(off&0x7fffffff) * 8
+	if j+8 > len(pf.large) {
+		return -1
+	}
+	return int64(binary.BigEndian.Uint64(pf.large[j:]))
+}
+
+// withPrefix returns the hashes of all objects in the pack that start with the hex prefix
+func (pf *packFile) withPrefix(prefix string) []string {
+	first, err := strconv.ParseUint(prefix[:2], 16, 8)
+	if err != nil {
+		return nil
+	}
+
+	lo, hi := 0, int(pf.fanout[first])
+	if first > 0 {
+		lo = int(pf.fanout[first-1])
+	}
+
+	found := []string{}
+	for i := lo; i < hi; i++ {
+		if h := hex.EncodeToString(pf.names[i*20 : i*20+20]); strings.HasPrefix(h, prefix) {
+			found = append(found, h)
+		}
+	}
+	return found
+}
+
+// readAt returns the type and contents of the object at offset, resolving any deltas
+func (pf *packFile) readAt(db *objectDB, offset int64) (int, []byte, error) {
+	if offset < 0 {
+		return 0, nil, errors.New("invalid pack offset")
+	}
+
+	if obj, ok := pf.cache[offset]; ok {
+		return obj.typ, obj.data, nil
+	}
+
+	br := bufio.NewReader(io.NewSectionReader(pf.file, offset, 1<<62))
+
+	// The header is the type and the inflated size as a variable length integer
+	c, err := br.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	typ := int(c>>4) & 7
+	size := int64(c & 0x0f)
+	for shift := 4; c&0x80 != 0; shift += 7 {
+		if c, err = br.ReadByte(); err != nil {
+			return 0, nil, err
+		}
+		size |= int64(c&0x7f) << shift
+	}
+
+	var baseType int
+	var base []byte
+
+	switch typ {
+	case packCommit, packTree, packBlob, packTag:
+		data, err := inflate(br, size)
+		return typ, data, err
+	case packOfsDelta:
+		// The base is at a negative offset from this object
+		if c, err = br.ReadByte(); err != nil {
+			return 0, nil, err
+		}
+		rel := int64(c & 0x7f)
+		for c&0x80 != 0 {
+			if c, err = br.ReadByte(); err != nil {
+				return 0, nil, err
+			}
+			rel = ((rel + 1) << 7) | int64(c&0x7f)
+		}
+
+		baseType, base, err = pf.readAt(db, offset-rel)
+		if err != nil {
+			return 0, nil, err
+		}
+		pf.remember(offset-rel, baseType, base)
+	case packRefDelta:
+		id := make([]byte, 20)
+		if _, err := io.ReadFull(br, id); err != nil {
+			return 0, nil, err
+		}
+
+		t, data, err := db.object(hex.EncodeToString(id))
+		if err != nil {
+			return 0, nil, err
+		}
+		base = data
+		for k, v := range packTypes {
+			if v == t {
+				baseType = k
+			}
+		}
+	default:
+		return 0, nil, fmt.Errorf("unknown object type %d", typ)
+	}
+
+	delta, err := inflate(br, size)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	data, err := applyDelta(base, delta)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return baseType, data, nil
+}
+
+// remember keeps a delta base in the cache, since many objects tend to share the same base
+func (pf *packFile) remember(offset int64, typ int, data []byte) {
+	if len(pf.cache) >= maxDeltaCache {
+		clear(pf.cache)
+	}
+	pf.cache[offset] = cachedObject{typ: typ, data: data}
+}
+
+// inflate reads size bytes of zlib compressed data from r
+func inflate(r io.Reader, size int64) ([]byte, error) {
+	zr, err := zlib.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(zr, data); err != nil {
+		return nil, fmt.Errorf("inflate object: %w", err)
+	}
+	return data, nil
+}
+
+// applyDelta rebuilds an object from its base and a git delta, which is made of copy instructions
+// (ranges of the base) and insert instructions (literal data).
+func applyDelta(base, delta []byte) ([]byte, error) {
+	errCorrupt := errors.New("corrupt delta")
+
+	// varint reads a little-endian base 128 integer from the start of the delta
+	varint := func() (int, bool) {
+		v := 0
+		for shift := 0; len(delta) > 0; shift += 7 {
+			c := delta[0]
+			delta = delta[1:]
+			v |= int(c&0x7f) << shift
+			if c&0x80 == 0 {
+				return v, true
+			}
+		}
+		return 0, false
+	}
+
+	srcSize, ok := varint()
+	if !ok || srcSize != len(base) {
+		return nil, errCorrupt
+	}
+
+	dstSize, ok := varint()
+	if !ok {
+		return nil, errCorrupt
+	}
+
+	out := make([]byte, 0, dstSize)
+	for len(delta) > 0 {
+		op := delta[0]
+		delta = delta[1:]
+
+		if op&0x80 == 0 {
+			// Insert the next op bytes, zero is reserved
+			n := int(op)
+			if n == 0 || n > len(delta) {
+				return nil, errCorrupt
+			}
+			out = append(out, delta[:n]...)
+			delta = delta[n:]
+			continue
+		}
+
+		// Copy: the low bits say which bytes of the offset and size follow
+		offset, size := 0, 0
+		for i := range 7 {
+			if op&(1<<i) == 0 {
+				continue
+			}
+			if len(delta) == 0 {
+				return nil, errCorrupt
+			}
+			if i < 4 {
+				offset |= int(delta[0]) << (8 * i)
+			} else {
+				size |= int(delta[0]) << (8 * (i - 4))
+			}
+			delta = delta[1:]
+		}
+
+		if size == 0 {
+			size = 0x10000
+		}
+
+		if offset+size > len(base) {
+			return nil, errCorrupt
+		}
+		out = append(out, base[offset:offset+size]...)
+	}
+
+	if len(out) != dstSize {
+		return nil, errCorrupt
+	}
+
+	return out, nil
+}
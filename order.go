@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// sameCommit reports whether the (possibly abbreviated) hashes a and b name the same commit
+func sameCommit(a, b string) bool {
+	if a == "" || b == "" {
+		return false
+	}
+	return strings.HasPrefix(a, b) || strings.HasPrefix(b, a)
+}
+
+// orderChanges sorts changes so that each change's parent is the change before it, and checks that
+// they form one unbroken chain. A change whose parent is missing from the list (other than the
+// first) means a commit was skipped, and its changes would silently be lost.
+func orderChanges(changes []Change) ([]Change, error) {
+	if len(changes) < 2 {
+		return changes, nil
+	}
+
+	// find returns the index of the change for hash, or -1
+	find := func(hash string) int {
+		for i, c := range changes {
+			if sameCommit(c.hash, hash) {
+				return i
+			}
+		}
+		return -1
+	}
+
+	roots := []int{}
+	children := make([]int, len(changes))
+	for i := range children {
+		children[i] = -1
+	}
+
+	for i, c := range changes {
+		if j := find(c.hash); j != i {
+			return nil, fmt.Errorf("commit %s is listed more than once", c.hash)
+		}
+
+		p := find(c.parent)
+		if p == -1 {
+			roots = append(roots, i)
+			continue
+		}
+
+		if children[p] != -1 {
+			return nil, fmt.Errorf("commits %s and %s both have parent %s, the commits must be a single linear history",
+				changes[children[p]].hash, c.hash, changes[p].hash)
+		}
+		children[p] = i
+	}
+
+	if len(roots) != 1 {
+		missing := []string{}
+		for _, i := range roots {
+			missing = append(missing, fmt.Sprintf("%s (parent %s)", changes[i].hash, changes[i].parent))
+		}
+
+		return nil, fmt.Errorf("commits do not form a single chain, these commits have a parent that is not being pushed: %s; is a commit missing from the list?",
+			strings.Join(missing, ", "))
+	}
+
+	ordered := make([]Change, 0, len(changes))
+	for i := roots[0]; i != -1; i = children[i] {
+		ordered = append(ordered, changes[i])
+	}
+
+	return ordered, nil
+}
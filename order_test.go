@@ -0,0 +1,100 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestOrderChanges(t *testing.T) {
+	chain := func(hashes ...string) []Change {
+		changes := []Change{}
+		parent := "base"
+		for _, h := range hashes {
+			changes = append(changes, Change{hash: h, parent: parent})
+			parent = h
+		}
+		return changes
+	}
+
+	hashesOf := func(changes []Change) []string {
+		out := []string{}
+		for _, c := range changes {
+			out = append(out, c.hash)
+		}
+		return out
+	}
+
+	testcases := []struct {
+		name    string
+		input   []Change
+		want    []string
+		wantErr string
+	}{{
+		name:  "already ordered",
+		input: chain("aaaa", "bbbb", "cccc"),
+		want:  []string{"aaaa", "bbbb", "cccc"},
+	}, {
+		name: "reversed",
+		input: func() []Change {
+			c := chain("aaaa", "bbbb", "cccc")
+			slices.Reverse(c)
+			return c
+		}(),
+		want: []string{"aaaa", "bbbb", "cccc"},
+	}, {
+		name: "shuffled",
+		input: func() []Change {
+			c := chain("aaaa", "bbbb", "cccc", "dddd")
+			return []Change{c[2], c[0], c[3], c[1]}
+		}(),
+		want: []string{"aaaa", "bbbb", "cccc", "dddd"},
+	}, {
+		name:  "single",
+		input: chain("aaaa"),
+		want:  []string{"aaaa"},
+	}, {
+		name: "abbreviated hashes",
+		input: []Change{
+			{hash: "bbbb", parent: "aaaa0000"},
+			{hash: "aaaa", parent: "base"},
+		},
+		want: []string{"aaaa", "bbbb"},
+	}, {
+		name: "gap",
+		input: func() []Change {
+			c := chain("aaaa", "bbbb", "cccc")
+			return []Change{c[0], c[2]}
+		}(),
+		wantErr: "cccc (parent bbbb)",
+	}, {
+		name: "fork",
+		input: []Change{
+			{hash: "aaaa", parent: "base"},
+			{hash: "bbbb", parent: "aaaa"},
+			{hash: "cccc", parent: "aaaa"},
+		},
+		wantErr: "both have parent aaaa",
+	}, {
+		name:    "duplicate",
+		input:   append(chain("aaaa", "bbbb"), Change{hash: "aaaa", parent: "base"}),
+		wantErr: "more than once",
+	}}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := orderChanges(tc.input)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tc.wantErr, err)
+				}
+				return
+			}
+
+			requireNoError(t, err)
+			if !slices.Equal(hashesOf(got), tc.want) {
+				t.Fatalf("wrong order, got=%q, want=%q", hashesOf(got), tc.want)
+			}
+		})
+	}
+}
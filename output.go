@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// Output formats, selected with --output
+const (
+	outputText = "text"
+	outputJSON = "json"
+)
+
+// pushResult describes a run of push or commit, printed to standard output with --output=json
+type pushResult struct {
+	Owner         string         `json:"owner"`
+	Repository    string         `json:"repository"`
+	Branch        string         `json:"branch"`
+	DryRun        bool           `json:"dry_run"`
+	BranchCreated bool           `json:"branch_created"`
+	StartHead     string         `json:"start_head"`
+	EndHead       string         `json:"end_head"`
+	Commits       []commitResult `json:"commits"`
+
+	// NothingToCommit is set when every change already matched the remote, so nothing was pushed
+	NothingToCommit bool `json:"nothing_to_commit"`
+
+	// Skipped lists the local commits left out because they didn't change anything on the remote
+	Skipped []string `json:"skipped"`
+
+	Error string `json:"error,omitempty"`
+
+	PullRequest *pullRequestResult `json:"pull_request,omitempty"`
+
+	// pushed is set once every commit is on the remote, after which the text output is still the
+	// new head if a later step such as opening the pull request fails
+	pushed bool
+}
+
+// newPushResult returns the result of a run pushing to the target of flags, before anything is
+// pushed
+func newPushResult(flags remoteFlags) pushResult {
+	return pushResult{
+		Owner:      flags.Target.Owner(),
+		Repository: flags.Target.Repository(),
+		Branch:     flags.Branch,
+		DryRun:     flags.DryRun,
+		Commits:    []commitResult{},
+		Skipped:    []string{},
+	}
+}
+
+// commitResult describes a single local change and the remote commit it was pushed as
+type commitResult struct {
+	LocalHash string       `json:"local_hash"`
+	RemoteOID string       `json:"remote_oid"`
+	URL       string       `json:"url"`
+	Headline  string       `json:"headline"`
+	Added     []fileResult `json:"added"`
+	Deleted   []string     `json:"deleted"`
+}
+
+type fileResult struct {
+	Path  string `json:"path"`
+	Bytes int    `json:"bytes"`
+
+	// Mode is set for files that are not regular files, see [Change.modes]
+	Mode string `json:"mode,omitempty"`
+
+	// Submodule is the commit a submodule points at, whose Bytes are 0
+	Submodule string `json:"submodule,omitempty"`
+}
+
+func newCommitResult(change Change, oid, url string) commitResult {
+	res := commitResult{
+		LocalHash: change.hash,
+		RemoteOID: oid,
+		URL:       url,
+		Headline:  change.Headline(),
+		Added:     []fileResult{},
+		Deleted:   []string{},
+	}
+
+	for _, p := range slices.Sorted(maps.Keys(change.entries)) {
+		content := change.entries[p]
+		if content == nil {
+			res.Deleted = append(res.Deleted, p)
+			continue
+		}
+
+		file := fileResult{Path: p, Bytes: len(content)}
+		if m := change.mode(p); m == modeSubmodule {
+			file = fileResult{Path: p, Mode: m, Submodule: strings.TrimSpace(string(content))}
+		} else if m != modeRegular {
+			file.Mode = m
+		}
+		res.Added = append(res.Added, file)
+	}
+
+	return res
+}
+
+// write prints the result to w in the given format. The text format is only the new head commit,
+// allowing callers to capture it in a script, and is empty if the push failed.
+func (r pushResult) write(w io.Writer, format string) error {
+	if format == outputJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(r)
+	}
+
+	if r.Error != "" && !r.pushed {
+		return nil
+	}
+
+	_, err := fmt.Fprintln(w, r.EndHead)
+	return err
+}
+
+// fail records err in the result and writes it to w, so that a JSON document is printed for every
+// failed run, and returns err
+func (r *pushResult) fail(w io.Writer, format string, err error) error {
+	r.Error = err.Error()
+	if werr := r.write(w, format); werr != nil {
+		log("Could not write output: %s\n", werr)
+	}
+	return err
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestPushResultWrite(t *testing.T) {
+	change := Change{
+		hash:    "1234",
+		message: "headline\n\nbody",
+		entries: map[string][]byte{
+			"b-file":  []byte("hello"),
+			"a-empty": {},
+			"deleted": nil,
+			"script":  []byte("#!/bin/sh"),
+			"sub":     []byte(strings.Repeat("1", 40)),
+		},
+		modes: map[string]string{"script": modeExecutable, "sub": modeSubmodule},
+	}
+
+	result := pushResult{
+		Owner:      "owner",
+		Repository: "repo",
+		Branch:     "branch",
+		StartHead:  "start",
+		EndHead:    "abcd",
+		Commits:    []commitResult{newCommitResult(change, "abcd", "https://github.com/owner/repo/commit/abcd")},
+	}
+
+	t.Run("text", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		requireNoError(t, result.write(out, outputText))
+
+		if out.String() != "abcd\n" {
+			t.Fatalf("expected only the head commit, got %q", out.String())
+		}
+	})
+
+	t.Run("json", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		requireNoError(t, result.write(out, outputJSON))
+
+		got := pushResult{}
+		requireNoError(t, json.Unmarshal(out.Bytes(), &got))
+
+		if got.StartHead != "start" || got.EndHead != "abcd" || len(got.Commits) != 1 {
+			t.Fatalf("unexpected result: %+v", got)
+		}
+
+		commit := got.Commits[0]
+		if commit.LocalHash != "1234" || commit.RemoteOID != "abcd" || commit.Headline != "headline" {
+			t.Errorf("unexpected commit: %+v", commit)
+		}
+
+		want := []fileResult{
+			{Path: "a-empty", Bytes: 0},
+			{Path: "b-file", Bytes: 5},
+			{Path: "script", Bytes: 9, Mode: modeExecutable},
+			{Path: "sub", Mode: modeSubmodule, Submodule: strings.Repeat("1", 40)},
+		}
+		if !slices.Equal(commit.Added, want) {
+			t.Errorf("unexpected additions: %+v", commit.Added)
+		}
+
+		if len(commit.Deleted) != 1 || commit.Deleted[0] != "deleted" {
+			t.Errorf("unexpected deletions: %q", commit.Deleted)
+		}
+	})
+
+	t.Run("text error", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		failed := result
+		failed.Error = "boom"
+		requireNoError(t, failed.write(out, outputText))
+
+		if out.Len() != 0 {
+			t.Fatalf("expected no output on error, got %q", out.String())
+		}
+	})
+
+	t.Run("text error after pushing", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		failed := result
+		failed.Error = "open pull request: boom"
+		failed.pushed = true
+		requireNoError(t, failed.write(out, outputText))
+
+		if out.String() != "abcd\n" {
+			t.Fatalf("expected the head commit, got %q", out.String())
+		}
+	})
+}
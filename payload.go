@@ -0,0 +1,163 @@
+package main
+
+import (
+	"cmp"
+	"encoding/base64"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// Handling of changes that are too large to push in a single request, selected with --oversized
+const (
+	oversizedIgnore = "ignore"
+	oversizedFail   = "fail"
+	oversizedSplit  = "split"
+)
+
+const (
+	// payloadOverhead approximates the size of the mutation and everything in its input other than
+	// the file changes
+	payloadOverhead = 1024
+
+	// entryOverhead approximates the JSON wrapping each file change
+	entryOverhead = 32
+)
+
+// entrySize estimates the encoded size of a single file change
+func entrySize(path string, content []byte) int {
+	return entryOverhead + len(path) + base64.StdEncoding.EncodedLen(len(content))
+}
+
+// payloadSize estimates the size of the request needed to push change, including the message as it
+// will be pushed, with the author and trailers added
+func payloadSize(change Change) int {
+	size := payloadOverhead + len(change.Headline()) + len(change.Body())
+
+	for p, content := range change.entries {
+		size += entrySize(p, content)
+	}
+
+	return size
+}
+
+// OversizedError is returned when a change is estimated to be larger than the payload limit
+type OversizedError struct {
+	Hash  string
+	Size  int
+	Limit int
+
+	// Largest lists the largest files in the change, largest first, by the size of their content
+	// rather than its encoded size in the request
+	Largest []fileResult
+}
+
+func (e *OversizedError) Error() string {
+	files := []string{}
+	for _, f := range e.Largest {
+		files = append(files, fmt.Sprintf("%s (%d bytes)", f.Path, f.Bytes))
+	}
+
+	return fmt.Sprintf("commit %s is an estimated %d bytes, over the limit of %d bytes; largest files: %s",
+		e.Hash, e.Size, e.Limit, strings.Join(files, ", "))
+}
+
+func newOversizedError(change Change, limit int) *OversizedError {
+	files := []fileResult{}
+	for p, content := range change.entries {
+		files = append(files, fileResult{Path: p, Bytes: len(content)})
+	}
+
+	slices.SortFunc(files, func(a, b fileResult) int {
+		return cmp.Or(cmp.Compare(b.Bytes, a.Bytes), cmp.Compare(a.Path, b.Path))
+	})
+
+	return &OversizedError{
+		Hash:    change.hash,
+		Size:    payloadSize(change),
+		Limit:   limit,
+		Largest: files[:min(len(files), 5)],
+	}
+}
+
+// limitPayloads checks every change against limit, according to mode. With oversizedFail, any
+// change over the limit is an error. With oversizedSplit, such changes are split into several
+// changes that each fit, see [splitOversized].
+func limitPayloads(mode string, limit int, changes []Change) ([]Change, error) {
+	if mode == oversizedIgnore {
+		return changes, nil
+	}
+
+	out := []Change{}
+	for _, c := range changes {
+		if payloadSize(c) <= limit {
+			out = append(out, c)
+			continue
+		}
+
+		if mode == oversizedFail {
+			return nil, newOversizedError(c, limit)
+		}
+
+		parts, err := splitOversized(c, limit)
+		if err != nil {
+			return nil, err
+		}
+
+		log("Commit %s is too large for a single request, splitting into %d commits.\n", c.hash, len(parts))
+		out = append(out, parts...)
+	}
+
+	return out, nil
+}
+
+// splitOversized splits change into sequential changes that each fit in limit. Every part keeps
+// the original message, with a "(part N/M)" suffix added to the headline.
+// It's an error for a single file to exceed the limit by itself.
+func splitOversized(change Change, limit int) ([]Change, error) {
+	empty := change
+	empty.entries = nil
+	base := payloadSize(empty) + len(" (part 00/00)")
+
+	groups := [][]string{}
+	size := limit
+	for _, p := range slices.Sorted(maps.Keys(change.entries)) {
+		es := entrySize(p, change.entries[p])
+		if base+es > limit {
+			return nil, newOversizedError(change, limit)
+		}
+
+		if size+es > limit {
+			groups = append(groups, nil)
+			size = base
+		}
+
+		groups[len(groups)-1] = append(groups[len(groups)-1], p)
+		size += es
+	}
+
+	headline, body := change.splitMessage()
+
+	parts := make([]Change, len(groups))
+	for i, paths := range groups {
+		part := change
+		part.message = fmt.Sprintf("%s (part %d/%d)", headline, i+1, len(groups))
+		if body != "" {
+			part.message += "\n\n" + body
+		}
+
+		part.entries = map[string][]byte{}
+		part.modes = map[string]string{}
+		for _, p := range paths {
+			part.entries[p] = change.entries[p]
+			if m, ok := change.modes[p]; ok {
+				part.modes[p] = m
+			}
+		}
+
+		parts[i] = part
+	}
+
+	return parts, nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestLimitPayloads(t *testing.T) {
+	logwriter = io.Discard
+
+	big := strings.Repeat("x", 3000)
+	change := Change{
+		hash:     "1234",
+		message:  "regenerate fixtures\n\nbody text",
+		trailers: []string{"Foo: bar"},
+		entries: map[string][]byte{
+			"a": []byte(big),
+			"b": []byte(big),
+			"c": []byte(big),
+			"d": nil,
+		},
+		modes: map[string]string{"b": modeExecutable},
+	}
+
+	// Room for two of the files per request
+	limit := payloadOverhead + 3*entrySize("a", []byte(big))
+
+	t.Run("ignore", func(t *testing.T) {
+		got, err := limitPayloads(oversizedIgnore, limit, []Change{change})
+		requireNoError(t, err)
+
+		if len(got) != 1 {
+			t.Fatalf("expected the change to be untouched, got %d changes", len(got))
+		}
+	})
+
+	t.Run("fail", func(t *testing.T) {
+		_, err := limitPayloads(oversizedFail, limit, []Change{change})
+
+		var oversized *OversizedError
+		if !errors.As(err, &oversized) {
+			t.Fatalf("expected an oversized error, got %v", err)
+		}
+
+		if len(oversized.Largest) != 4 || oversized.Largest[0].Path != "a" || oversized.Largest[3].Path != "d" {
+			t.Fatalf("expected files ordered largest first, got %+v", oversized.Largest)
+		}
+
+		if oversized.Largest[0].Bytes != len(big) {
+			t.Fatalf("expected the size of the file, got %d", oversized.Largest[0].Bytes)
+		}
+	})
+
+	t.Run("split", func(t *testing.T) {
+		got, err := limitPayloads(oversizedSplit, limit, []Change{change})
+		requireNoError(t, err)
+
+		if len(got) != 2 {
+			t.Fatalf("expected 2 parts, got %d", len(got))
+		}
+
+		seen := map[string]bool{}
+		for i, part := range got {
+			if size := payloadSize(part); size > limit {
+				t.Errorf("part %d is %d bytes, over the limit of %d", i+1, size, limit)
+			}
+
+			for p := range part.entries {
+				seen[p] = true
+			}
+
+			if part.hash != "1234" || part.Body() != "body text\n\nFoo: bar" {
+				t.Errorf("part %d lost its hash or body: %q %q", i+1, part.hash, part.Body())
+			}
+		}
+
+		if len(seen) != 4 {
+			t.Errorf("expected all 4 paths across the parts, got %d", len(seen))
+		}
+
+		if h := got[0].Headline(); h != "regenerate fixtures (part 1/2)" {
+			t.Errorf("wrong headline for part 1: %q", h)
+		}
+
+		if h := got[1].Headline(); h != "regenerate fixtures (part 2/2)" {
+			t.Errorf("wrong headline for part 2: %q", h)
+		}
+
+		if got[0].mode("b") != modeExecutable && got[1].mode("b") != modeExecutable {
+			t.Error("expected the mode of b to be kept")
+		}
+	})
+
+	t.Run("single file too large", func(t *testing.T) {
+		_, err := limitPayloads(oversizedSplit, payloadOverhead+100, []Change{change})
+
+		var oversized *OversizedError
+		if !errors.As(err, &oversized) {
+			t.Fatalf("expected an oversized error, got %v", err)
+		}
+	})
+}
+
+func TestPayloadSize(t *testing.T) {
+	change := Change{hash: "1234", message: "subject\n\nbody"}
+	size := payloadSize(change)
+
+	// The author and trailers are part of the pushed message
+	change.author = "A U Thor <author@example.com>"
+	change.trailers = []string{"Foo: " + strings.Repeat("x", 100)}
+	want := size + len("\n\nCo-authored-by: A U Thor <author@example.com>\nFoo: ") + 100
+	if got := payloadSize(change); got != want {
+		t.Fatalf("expected %d, got %d", want, got)
+	}
+}
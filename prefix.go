@@ -0,0 +1,85 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"path"
+	"slices"
+	"strings"
+)
+
+// cleanPrefix normalises a path prefix given on the command line, returning an empty string for no
+// prefix
+func cleanPrefix(prefix string) string {
+	prefix = path.Clean("/" + prefix)
+	return strings.TrimPrefix(prefix, "/")
+}
+
+// relocate moves p from under the directory strip to under the directory add, either of which may
+// be empty. It reports false if p is not inside strip. A path equal to strip becomes ".".
+func relocate(p, strip, add string) (string, bool) {
+	if strip != "" {
+		if p == strip {
+			p = "."
+		} else if rest, ok := strings.CutPrefix(p, strip+"/"); ok {
+			p = rest
+		} else {
+			return "", false
+		}
+	}
+
+	if add != "" {
+		p = path.Join(add, p)
+	}
+
+	return p, true
+}
+
+// relocateChange returns a copy of change with every path moved from under strip to under add, see
+// [relocate]. Files outside of strip are an error, since they can't be placed on the remote.
+func relocateChange(change Change, strip, add string) (Change, error) {
+	if strip == "" && add == "" {
+		return change, nil
+	}
+
+	out := change
+	out.entries = map[string][]byte{}
+	out.modes = map[string]string{}
+	out.mirrors = nil
+
+	outside := []string{}
+	for _, p := range slices.Sorted(maps.Keys(change.entries)) {
+		np, ok := relocate(p, strip, add)
+		if !ok || p == strip {
+			outside = append(outside, p)
+			continue
+		}
+
+		out.entries[np] = change.entries[p]
+		if m, ok := change.modes[p]; ok {
+			out.modes[np] = m
+		}
+	}
+
+	for _, m := range change.mirrors {
+		local := cmp.Or(m.local, m.dir)
+		dir, ok := relocate(m.dir, strip, add)
+		if !ok && m.dir != "." {
+			outside = append(outside, m.dir)
+			continue
+		} else if !ok {
+			// Mirroring everything only mirrors what is inside strip
+			dir, local = cmp.Or(add, "."), strip
+		}
+
+		out.mirrors = append(out.mirrors, mirrorDir{dir: dir, exclude: m.exclude, local: local})
+	}
+
+	if len(outside) > 0 {
+		return Change{}, fmt.Errorf("commit %s changes paths outside of the stripped prefix %q: %s",
+			change.hash, strip, strings.Join(outside, ", "))
+	}
+
+	return out, nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestCleanPrefix(t *testing.T) {
+	for input, want := range map[string]string{
+		"":           "",
+		".":          "",
+		"/":          "",
+		"docs/api/":  "docs/api",
+		"/docs//api": "docs/api",
+		"./docs":     "docs",
+	} {
+		if got := cleanPrefix(input); got != want {
+			t.Errorf("cleanPrefix(%q): expected %q, got %q", input, want, got)
+		}
+	}
+}
+
+func TestRelocateChange(t *testing.T) {
+	change := Change{
+		hash: "1234",
+		entries: map[string][]byte{
+			"build/api/index.html": []byte("index"),
+			"build/api/run.sh":     []byte("run"),
+			"build/api/old.html":   nil,
+		},
+		modes:   map[string]string{"build/api/run.sh": modeExecutable},
+		mirrors: []mirrorDir{{dir: "build/api", exclude: []string{"build/api/keep.txt", "*.bak"}}},
+	}
+
+	got, err := relocateChange(change, "build", "docs")
+	requireNoError(t, err)
+
+	want := []string{"docs/api/index.html", "docs/api/old.html", "docs/api/run.sh"}
+	if paths := slices.Sorted(maps.Keys(got.entries)); !slices.Equal(paths, want) {
+		t.Fatalf("expected paths %q, got %q", want, paths)
+	}
+
+	if got.entries["docs/api/old.html"] != nil || got.mode("docs/api/run.sh") != modeExecutable {
+		t.Errorf("expected deletions and modes to be kept, got %+v", got)
+	}
+
+	if len(got.mirrors) != 1 || got.mirrors[0].dir != "docs/api" {
+		t.Errorf("expected the mirror to move to docs/api, got %+v", got.mirrors)
+	}
+
+	// Exclude patterns still apply to the remote paths the local paths moved to
+	tree := map[string]remoteFile{
+		"docs/api/keep.txt":   {},
+		"docs/api/a.bak":      {},
+		"docs/api/stale.html": {},
+		"docs/other.html":     {},
+	}
+	expandMirrors(got, tree)
+	if _, ok := got.entries["docs/api/stale.html"]; !ok || len(got.entries) != 4 {
+		t.Errorf("expected only docs/api/stale.html to be deleted, got %q", slices.Sorted(maps.Keys(got.entries)))
+	}
+
+	// Mirroring the whole stripped directory
+	everything := Change{hash: "1234", entries: map[string][]byte{}, mirrors: []mirrorDir{{dir: ".", exclude: []string{"build/keep.txt"}}}}
+	got, err = relocateChange(everything, "build", "")
+	requireNoError(t, err)
+
+	expandMirrors(got, map[string]remoteFile{"keep.txt": {}, "stale.html": {}})
+	if paths := slices.Sorted(maps.Keys(got.entries)); !slices.Equal(paths, []string{"stale.html"}) {
+		t.Errorf("expected only stale.html to be deleted, got %q", paths)
+	}
+
+	// The original change is untouched
+	if _, ok := change.entries["build/api/index.html"]; !ok {
+		t.Error("expected the original change not to be modified")
+	}
+
+	change.entries["README"] = []byte("readme")
+	_, err = relocateChange(change, "build", "docs")
+	if err == nil || !strings.HasSuffix(err.Error(), ": README") {
+		t.Fatalf("expected an error listing README, got %v", err)
+	}
+
+	got, err = relocateChange(change, "", "sub")
+	requireNoError(t, err)
+	if _, ok := got.entries["sub/README"]; !ok {
+		t.Errorf("expected README to move to sub/README, got %q", slices.Collect(maps.Keys(got.entries)))
+	}
+}
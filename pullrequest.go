@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// flags used to open or update a pull request from the target branch after pushing
+type pullRequestFlags struct {
+	OpenPR      bool     `name:"open-pr" help:"After pushing, open a pull request from the branch, or update the open one. The URL is logged, and only printed to standard output with --output=json."`
+	PRBase      string   `name:"pr-base" help:"Base branch of the pull request. Defaults to the default branch of the repository."`
+	PRTitle     string   `name:"pr-title" help:"Title of the pull request. Defaults to the headline of the last pushed commit."`
+	PRBody      string   `name:"pr-body" help:"Body of the pull request."`
+	PRLabels    []string `name:"pr-label" help:"Label to add to the pull request. May be repeated."`
+	PRReviewers []string `name:"pr-reviewer" help:"User, or org/team, to request a review from. May be repeated."`
+	PRDraft     bool     `name:"pr-draft" help:"Open the pull request as a draft. Has no effect on existing pull requests."`
+}
+
+// pullRequestResult describes the pull request opened or updated after a push
+type pullRequestResult struct {
+	Number  int    `json:"number"`
+	URL     string `json:"url"`
+	Created bool   `json:"created"`
+}
+
+type pullRequest struct {
+	Number  int    `json:"number"`
+	HTMLURL string `json:"html_url"`
+}
+
+func (c *Client) repoURL() string {
+	return fmt.Sprintf("%s/repos/%s/%s", c.baseURL, c.owner, c.repo)
+}
+
+// DefaultBranch returns the name of the default branch of the repository
+func (c *Client) DefaultBranch(ctx context.Context) (string, error) {
+	payload := struct {
+		DefaultBranch string `json:"default_branch"`
+	}{}
+
+	if _, err := c.doJSON(ctx, http.MethodGet, c.repoURL(), nil, http.StatusOK, &payload); err != nil {
+		return "", fmt.Errorf("get repository: %w", err)
+	}
+
+	return payload.DefaultBranch, nil
+}
+
+// FindPullRequest returns the open pull request with c.branch as its head, or nil if there isn't one
+func (c *Client) FindPullRequest(ctx context.Context) (*pullRequest, error) {
+	query := url.Values{
+		"head":  {fmt.Sprintf("%s:%s", c.owner, c.branch)},
+		"state": {"open"},
+	}
+
+	prs := []pullRequest{}
+	if _, err := c.doJSON(ctx, http.MethodGet, c.repoURL()+"/pulls?"+query.Encode(), nil, http.StatusOK, &prs); err != nil {
+		return nil, fmt.Errorf("list pull requests: %w", err)
+	}
+
+	if len(prs) == 0 {
+		return nil, nil
+	}
+
+	return &prs[0], nil
+}
+
+// OpenPullRequest creates a pull request from c.branch, or updates the existing open one, and then
+// applies any labels and reviewers. Title and body are only changed on an existing pull request if
+// they are set in flags.
+func (c *Client) OpenPullRequest(ctx context.Context, flags pullRequestFlags, defaultTitle string) (pullRequestResult, error) {
+	existing, err := c.FindPullRequest(ctx)
+	if err != nil {
+		return pullRequestResult{}, err
+	}
+
+	result := pullRequestResult{}
+	pr := &pullRequest{}
+
+	if existing != nil {
+		update := map[string]any{}
+		if flags.PRTitle != "" {
+			update["title"] = flags.PRTitle
+		}
+		if flags.PRBody != "" {
+			update["body"] = flags.PRBody
+		}
+		if flags.PRBase != "" {
+			update["base"] = flags.PRBase
+		}
+
+		pr = existing
+		if len(update) > 0 {
+			url := fmt.Sprintf("%s/pulls/%d", c.repoURL(), existing.Number)
+			if _, err := c.doJSON(ctx, http.MethodPatch, url, update, http.StatusOK, pr); err != nil {
+				return result, fmt.Errorf("update pull request #%d: %w", existing.Number, err)
+			}
+		}
+
+		log("Updated pull request #%d\n", pr.Number)
+	} else {
+		base := flags.PRBase
+		if base == "" {
+			base, err = c.DefaultBranch(ctx)
+			if err != nil {
+				return result, err
+			}
+		}
+
+		title := flags.PRTitle
+		if title == "" {
+			title = defaultTitle
+		}
+
+		create := map[string]any{
+			"title": title,
+			"head":  c.branch,
+			"base":  base,
+			"body":  flags.PRBody,
+			"draft": flags.PRDraft,
+		}
+		if _, err := c.doJSON(ctx, http.MethodPost, c.repoURL()+"/pulls", create, http.StatusCreated, pr); err != nil {
+			return result, fmt.Errorf("create pull request: %w", err)
+		}
+
+		result.Created = true
+		log("Created pull request #%d\n", pr.Number)
+	}
+
+	result.Number, result.URL = pr.Number, pr.HTMLURL
+
+	if len(flags.PRLabels) > 0 {
+		url := fmt.Sprintf("%s/issues/%d/labels", c.repoURL(), pr.Number)
+		if _, err := c.doJSON(ctx, http.MethodPost, url, map[string]any{"labels": flags.PRLabels}, http.StatusOK, nil); err != nil {
+			return result, fmt.Errorf("add labels to pull request #%d: %w", pr.Number, err)
+		}
+	}
+
+	if len(flags.PRReviewers) > 0 {
+		users, teams := []string{}, []string{}
+		for _, r := range flags.PRReviewers {
+			// teams are given as org/team, but the API only wants the team slug
+			if _, team, ok := strings.Cut(r, "/"); ok {
+				teams = append(teams, team)
+			} else {
+				users = append(users, r)
+			}
+		}
+
+		url := fmt.Sprintf("%s/pulls/%d/requested_reviewers", c.repoURL(), pr.Number)
+		input := map[string]any{"reviewers": users, "team_reviewers": teams}
+		if _, err := c.doJSON(ctx, http.MethodPost, url, input, http.StatusCreated, nil); err != nil {
+			return result, fmt.Errorf("request reviewers for pull request #%d: %w", pr.Number, err)
+		}
+	}
+
+	return result, nil
+}
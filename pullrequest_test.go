@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestOpenPullRequest(t *testing.T) {
+	logwriter = io.Discard
+
+	type request struct {
+		method, path string
+		body         map[string]any
+	}
+
+	newServer := func(t *testing.T, existing bool) (*Client, *[]request) {
+		requests := []request{}
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			req := request{method: r.Method, path: r.URL.Path}
+			if r.Method != http.MethodGet {
+				requireNoError(t, json.NewDecoder(r.Body).Decode(&req.body))
+			}
+			requests = append(requests, req)
+
+			switch {
+			case r.Method == http.MethodGet && r.URL.Path == "/repos/owner/repo/pulls":
+				if r.URL.Query().Get("head") != "owner:branch" {
+					t.Errorf("unexpected head filter %q", r.URL.Query().Get("head"))
+				}
+				if existing {
+					fmt.Fprint(w, `[{"number": 7, "html_url": "https://github.com/owner/repo/pull/7"}]`)
+				} else {
+					fmt.Fprint(w, `[]`)
+				}
+			case r.Method == http.MethodGet && r.URL.Path == "/repos/owner/repo":
+				fmt.Fprint(w, `{"default_branch": "main"}`)
+			case r.Method == http.MethodPost && r.URL.Path == "/repos/owner/repo/pulls":
+				w.WriteHeader(http.StatusCreated)
+				fmt.Fprint(w, `{"number": 8, "html_url": "https://github.com/owner/repo/pull/8"}`)
+			case r.Method == http.MethodPatch && r.URL.Path == "/repos/owner/repo/pulls/7":
+				fmt.Fprint(w, `{"number": 7, "html_url": "https://github.com/owner/repo/pull/7"}`)
+			case r.Method == http.MethodPost && r.URL.Path == "/repos/owner/repo/issues/7/labels":
+				fmt.Fprint(w, `[]`)
+			case r.Method == http.MethodPost && r.URL.Path == "/repos/owner/repo/pulls/8/requested_reviewers":
+				w.WriteHeader(http.StatusCreated)
+				fmt.Fprint(w, `{}`)
+			default:
+				t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+		t.Cleanup(server.Close)
+
+		client := &Client{httpC: server.Client(), owner: "owner", repo: "repo", branch: "branch", baseURL: server.URL}
+		return client, &requests
+	}
+
+	t.Run("create", func(t *testing.T) {
+		client, requests := newServer(t, false)
+
+		flags := pullRequestFlags{OpenPR: true, PRDraft: true, PRReviewers: []string{"octocat", "org/team"}}
+		result, err := client.OpenPullRequest(context.Background(), flags, "last headline")
+		requireNoError(t, err)
+
+		if result != (pullRequestResult{Number: 8, URL: "https://github.com/owner/repo/pull/8", Created: true}) {
+			t.Fatalf("unexpected result: %+v", result)
+		}
+
+		create := (*requests)[2].body
+		if create["title"] != "last headline" || create["base"] != "main" || create["head"] != "branch" || create["draft"] != true {
+			t.Errorf("unexpected create request: %v", create)
+		}
+
+		reviewers := (*requests)[3].body
+		if reviewers["reviewers"].([]any)[0] != "octocat" || reviewers["team_reviewers"].([]any)[0] != "team" {
+			t.Errorf("unexpected reviewers request: %v", reviewers)
+		}
+	})
+
+	t.Run("update", func(t *testing.T) {
+		client, requests := newServer(t, true)
+
+		flags := pullRequestFlags{OpenPR: true, PRTitle: "new title", PRLabels: []string{"bot"}}
+		result, err := client.OpenPullRequest(context.Background(), flags, "last headline")
+		requireNoError(t, err)
+
+		if result != (pullRequestResult{Number: 7, URL: "https://github.com/owner/repo/pull/7"}) {
+			t.Fatalf("unexpected result: %+v", result)
+		}
+
+		update := (*requests)[1].body
+		if update["title"] != "new title" || len(update) != 1 {
+			t.Errorf("unexpected update request: %v", update)
+		}
+
+		labels := (*requests)[2].body["labels"].([]any)
+		if !slices.Equal(labels, []any{"bot"}) {
+			t.Errorf("unexpected labels: %v", labels)
+		}
+	})
+}
@@ -1,16 +1,26 @@
 package main
 
 import (
+	"cmp"
 	"context"
 	"errors"
 	"fmt"
+	"maps"
 	"os"
+	"slices"
 	"strings"
 )
 
 // Takes a list of changes to push to the remote identified by target.
-// Prints the last commit pushed to standard output.
-func pushChanges(ctx context.Context, owner, repository, branch, headSha string, createBranch, dryrun bool, changes ...Change) error {
+// Prints the last commit pushed to standard output, or a description of the whole push when using
+// JSON output, see [pushResult]. The JSON description is also printed if the push fails.
+func pushChanges(ctx context.Context, flags remoteFlags, changes ...Change) error {
+	owner, repository, branch := flags.Target.Owner(), flags.Target.Repository(), flags.Branch
+	headSha, createBranch := flags.HeadSha, flags.CreateBranch
+
+	result := newPushResult(flags)
+	fail := func(err error) error { return result.fail(outwriter, flags.Output, err) }
+
 	hashes := []string{}
 	for i := 0; i < len(changes) && i < 10; i++ {
 		hashes = append(hashes, changes[i].hash)
@@ -23,38 +33,129 @@ func pushChanges(ctx context.Context, owner, repository, branch, headSha string,
 	log("Owner: %s\n", owner)
 	log("Repository: %s\n", repository)
 	log("Branch: %s\n", branch)
+	log("API: %s\n", flags.APIURL.REST())
+	if flags.StripPrefix != "" || flags.Prefix != "" {
+		log("Paths: %s/ -> %s/\n", cmp.Or(cleanPrefix(flags.StripPrefix), "."), cmp.Or(cleanPrefix(flags.Prefix), "."))
+	}
 	log("Commits: %s\n", strings.Join(hashes, ", "))
 
 	if headSha != "" && (!hashRegex.MatchString(headSha) || len(headSha) != 40) {
-		return fmt.Errorf("invalid head-sha %q, must be a full 40 hex digit commit hash", headSha)
+		return fail(fmt.Errorf("invalid head-sha %q, must be a full 40 hex digit commit hash", headSha))
 	}
 
 	if createBranch && headSha == "" {
-		return errors.New("cannot use --create-branch without supplying --head-sha")
+		return fail(errors.New("cannot use --create-branch without supplying --head-sha"))
 	}
 
-	token := getToken(os.Getenv)
-	if token == "" {
-		return errors.New("no GitHub token supplied")
+	// Map local paths to remote paths before anything looks at the remote
+	strip, prefix := cleanPrefix(flags.StripPrefix), cleanPrefix(flags.Prefix)
+	for i, c := range changes {
+		relocated, err := relocateChange(c, strip, prefix)
+		if err != nil {
+			return fail(err)
+		}
+		changes[i] = relocated
 	}
 
-	client := NewClient(ctx, token, owner, repository, branch)
-	client.dryrun = dryrun
+	if err := applyMessageFlags(flags.messageFlags, branch, owner+"/"+repository, os.Getenv, changes); err != nil {
+		return fail(err)
+	}
+
+	// Refuse before anything is pushed, rather than part way through
+	if err := checkSubmodules(flags.Backend, changes); err != nil {
+		return fail(err)
+	}
+
+	tokensrc, err := flags.tokenSource(ctx, os.Getenv, flags.APIURL.REST())
+	if err != nil {
+		return fail(err)
+	}
+
+	client := NewClient(ctx, tokensrc, flags.APIURL, owner, repository, branch)
+	client.dryrun = flags.DryRun
+	client.rebase = flags.Rebase
+	client.backend = flags.Backend
+
+	if flags.Backend == backendREST {
+		log("Warning: commits created with the REST backend may not be signed by GitHub.\n")
+	}
 
 	if headSha == "" {
 		remoteSha, err := client.GetHeadCommitHash(context.Background())
 		if err != nil {
-			return err
+			return fail(err)
 		}
 		headSha = remoteSha
-	} else if createBranch {
+	}
+
+	// Mirrored directories, deletions and unchanged files are compared with the tree the changes
+	// will be pushed on top of, which is also the branch point of a new branch
+	mirrored := slices.ContainsFunc(changes, func(c Change) bool { return len(c.mirrors) > 0 })
+	deletes := slices.ContainsFunc(changes, Change.hasDeletions)
+	local := changes
+	if mirrored || deletes || flags.SkipUnchanged {
+		tree, err := client.GetTree(ctx, headSha)
+		if errors.Is(err, errTreeTruncated) && !mirrored {
+			// The checks are only there to fail early or push less, so push everything instead
+			log("Remote tree is too large to compare, pushing every file.\n")
+		} else if err != nil {
+			return fail(err)
+		} else {
+			for _, c := range changes {
+				if n := expandMirrors(c, tree); n > 0 {
+					log("Deleting %d remote files missing from mirrored directories.\n", n)
+				}
+			}
+
+			changes, err = checkDeletions(changes, maps.Clone(tree), headSha, flags.IgnoreMissing)
+			if err != nil {
+				return fail(err)
+			}
+
+			if flags.SkipUnchanged {
+				// Remote commits changing the files left out would replace the content being pushed
+				changes, client.unchanged = dropUnchanged(changes, tree)
+			}
+		}
+	}
+
+	// Report the commits left out because they don't change anything on the remote
+	for _, c := range local {
+		if !slices.ContainsFunc(changes, func(kept Change) bool { return kept.hash == c.hash }) {
+			result.Skipped = append(result.Skipped, c.hash)
+		}
+	}
+
+	if len(changes) == 0 {
+		log("Nothing to commit, every change already matches the remote.\n")
+		result.NothingToCommit = true
+		result.StartHead, result.EndHead = headSha, headSha
+		if err := result.write(outwriter, flags.Output); err != nil {
+			return err
+		}
+
+		if flags.NoopExitCode != 0 {
+			return exitStatus(flags.NoopExitCode)
+		}
+		return nil
+	}
+
+	changes, err = limitPayloads(flags.Oversized, flags.MaxPayload, changes)
+	if err != nil {
+		return fail(err)
+	}
+
+	if createBranch {
 		remoteSha, err := client.CreateBranch(ctx, headSha)
 		if err != nil {
-			return err
+			return fail(err)
 		}
 		headSha = remoteSha
+		result.BranchCreated = true
 	}
 
+	result.StartHead = headSha
+
 	log("Remote head commit: %s\n", headSha)
 	for _, c := range changes {
 		log("Commit %s\n", c.hash)
@@ -66,23 +167,54 @@ func pushChanges(ctx context.Context, owner, repository, branch, headSha string,
 			if content == nil {
 				action = "DELETE"
 			}
-			log("    - %s: %s\n", action, p)
+			if m := c.mode(p); content != nil && m != modeRegular {
+				log("    - %s: %s (mode %s)\n", action, p, m)
+			} else {
+				log("    - %s: %s\n", action, p)
+			}
 		}
 	}
 
-	pushed, newHead, err := client.PushChanges(ctx, headSha, changes...)
+	pushed, err := client.PushChanges(ctx, headSha, changes...)
+
+	result.EndHead = headSha
+	for i, oid := range pushed {
+		result.Commits = append(result.Commits, newCommitResult(changes[i], oid, client.commitURL(oid)))
+		result.EndHead = oid
+	}
+
 	if err != nil {
-		return err
-	} else if pushed != len(changes) {
-		return fmt.Errorf("pushed %d of %d changes", pushed, len(changes))
+		log("Pushed %d of %d commits before failing.\n", len(pushed), len(changes))
+		for _, c := range changes[:len(pushed)] {
+			log("  - %s\n", c.hash)
+		}
+		if len(pushed) > 0 {
+			log("Remote head is now %s\n", result.EndHead)
+		}
+
+		return fail(err)
+	} else if len(pushed) != len(changes) {
+		return fail(fmt.Errorf("pushed %d of %d changes", len(pushed), len(changes)))
 	}
 
 	log("Pushed %d commits.\n", len(changes))
 	log("Branch URL: %s\n", client.browseCommitsURL())
+	result.pushed = true
+
+	if flags.OpenPR && flags.DryRun {
+		log("Dry run enabled, not opening a pull request.\n")
+	} else if flags.OpenPR {
+		pr, err := client.OpenPullRequest(ctx, flags.pullRequestFlags, changes[len(changes)-1].Headline())
+		if err != nil {
+			// The commits are already pushed, so callers still need the new head
+			return fail(fmt.Errorf("open pull request: %w", err))
+		}
 
-	// The only thing that goes to standard output is the new head reference, allowing callers to
-	// capture stdout if they need the reference.
-	fmt.Println(newHead)
+		log("Pull request URL: %s\n", pr.URL)
+		result.PullRequest = &pr
+	}
 
-	return nil
+	// The only thing that goes to standard output is the new head reference (or the JSON result),
+	// allowing callers to capture stdout if they need the reference.
+	return result.write(outwriter, flags.Output)
 }
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+const (
+	// maxRebases limits how many times a single push will chase a moving remote head
+	maxRebases = 5
+
+	// maxCompareFiles is the number of files listed by the compare API before it truncates
+	maxCompareFiles = 300
+)
+
+// ConflictError is returned when the remote branch gained commits that touch the same paths as
+// changes that have not been pushed yet.
+type ConflictError struct {
+	Base  string
+	Head  string
+	Paths []string
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("remote moved from %s to %s and changed files that are still to be pushed: %s",
+		e.Base, e.Head, strings.Join(e.Paths, ", "))
+}
+
+// rebaseOnto fetches the new remote head and checks whether the remote commits made since base
+// overlap with the pending changes, or with the files left out of the push because they matched
+// the remote before it moved. If they don't, it returns the new head to push on top of.
+func (c *Client) rebaseOnto(ctx context.Context, base string, pending []Change) (string, error) {
+	head, err := c.GetHeadCommitHash(ctx)
+	if err != nil {
+		return base, err
+	}
+
+	log("Remote head moved from %s to %s, checking for conflicts.\n", base, head)
+
+	remotePaths, err := c.ChangedPaths(ctx, base, head)
+	if err != nil {
+		return base, err
+	}
+
+	if overlap := overlappingPaths(remotePaths, pending, c.unchanged); len(overlap) > 0 {
+		log("Conflicting paths:\n")
+		for _, p := range overlap {
+			log("  - %s\n", p)
+		}
+
+		return base, &ConflictError{Base: base, Head: head, Paths: overlap}
+	}
+
+	log("No conflicts, retrying on top of %s.\n", head)
+	return head, nil
+}
+
+// overlappingPaths returns the sorted set of paths in remote that are also touched by any of the
+// pending changes, or are in unchanged
+func overlappingPaths(remote []string, pending []Change, unchanged []string) []string {
+	touched := map[string]bool{}
+	for _, c := range pending {
+		for p := range c.entries {
+			touched[p] = true
+		}
+	}
+
+	for _, p := range unchanged {
+		touched[p] = true
+	}
+
+	overlap := []string{}
+	for _, p := range remote {
+		if touched[p] && !slices.Contains(overlap, p) {
+			overlap = append(overlap, p)
+		}
+	}
+
+	slices.Sort(overlap)
+	return overlap
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestOverlappingPaths(t *testing.T) {
+	pending := []Change{{
+		entries: map[string][]byte{"a": []byte("a"), "b": nil},
+	}, {
+		entries: map[string][]byte{"c/d": []byte("d")},
+	}}
+
+	got := overlappingPaths([]string{"z", "c/d", "b", "b", "c"}, pending, nil)
+	if want := []string{"b", "c/d"}; !slices.Equal(got, want) {
+		t.Fatalf("wrong overlap, got=%q, want=%q", got, want)
+	}
+
+	if got := overlappingPaths([]string{"x", "y"}, pending, nil); len(got) != 0 {
+		t.Fatalf("expected no overlap, got %q", got)
+	}
+
+	// Paths left out of the push still conflict
+	got = overlappingPaths([]string{"x", "y"}, pending, []string{"y"})
+	if want := []string{"y"}; !slices.Equal(got, want) {
+		t.Fatalf("wrong overlap, got=%q, want=%q", got, want)
+	}
+}
+
+// rebaseServer fakes a remote whose head moves from "base" to "moved" before the first commit is
+// created, with the file remotePath changed in between.
+func rebaseServer(t *testing.T, remotePath string) *httptest.Server {
+	head := "moved"
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /repos/owner/repo/branches/branch", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"commit": {"sha": %q}}`, head)
+	})
+	mux.HandleFunc("GET /repos/owner/repo/compare/base...moved", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"status": "ahead", "files": [{"filename": %q}]}`, remotePath)
+	})
+	mux.HandleFunc("POST /graphql", func(w http.ResponseWriter, r *http.Request) {
+		query := struct {
+			Variables struct {
+				Input createCommitOnBranchInput
+			}
+		}{}
+		if err := json.NewDecoder(r.Body).Decode(&query); err != nil {
+			t.Error(err)
+		}
+
+		if expected := query.Variables.Input.ExpectedRef; expected != head {
+			fmt.Fprintf(w, `{"errors": [{"type": "STALE_DATA", "message": "Expected branch to point to \"%s\" but it did not. Pull and try again."}]}`, expected)
+			return
+		}
+
+		head = "pushed-" + query.Variables.Input.Message.Headline
+		fmt.Fprintf(w, `{"data": {"createCommitOnBranch": {"commit": {"oid": %q}}}}`, head)
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestPushChangesRebase(t *testing.T) {
+	logwriter = io.Discard
+
+	changes := []Change{{
+		hash:    "1111",
+		message: "first",
+		entries: map[string][]byte{"a": []byte("a")},
+	}, {
+		hash:    "2222",
+		message: "second",
+		entries: map[string][]byte{"b": []byte("b")},
+	}}
+
+	t.Run("no conflict", func(t *testing.T) {
+		server := rebaseServer(t, "unrelated")
+		client := &Client{httpC: server.Client(), owner: "owner", repo: "repo", branch: "branch", baseURL: server.URL, graphqlURL: server.URL + "/graphql", rebase: true}
+
+		pushed, err := client.PushChanges(context.Background(), "base", changes...)
+		requireNoError(t, err)
+
+		if want := []string{"pushed-first", "pushed-second"}; !slices.Equal(pushed, want) {
+			t.Fatalf("expected both changes to be pushed, got=%q, want=%q", pushed, want)
+		}
+	})
+
+	t.Run("conflict", func(t *testing.T) {
+		server := rebaseServer(t, "b")
+		client := &Client{httpC: server.Client(), owner: "owner", repo: "repo", branch: "branch", baseURL: server.URL, graphqlURL: server.URL + "/graphql", rebase: true}
+
+		pushed, err := client.PushChanges(context.Background(), "base", changes...)
+
+		var conflict *ConflictError
+		if !errors.As(err, &conflict) {
+			t.Fatalf("expected a conflict error, got %v", err)
+		}
+
+		if len(pushed) != 0 || !slices.Equal(conflict.Paths, []string{"b"}) {
+			t.Fatalf("unexpected conflict report, pushed=%q paths=%q", pushed, conflict.Paths)
+		}
+	})
+
+	t.Run("conflict with an unchanged file", func(t *testing.T) {
+		// c matched the remote before it moved, so it was left out of the push
+		server := rebaseServer(t, "c")
+		client := &Client{httpC: server.Client(), owner: "owner", repo: "repo", branch: "branch", baseURL: server.URL, graphqlURL: server.URL + "/graphql", rebase: true, unchanged: []string{"c"}}
+
+		pushed, err := client.PushChanges(context.Background(), "base", changes...)
+
+		var conflict *ConflictError
+		if !errors.As(err, &conflict) {
+			t.Fatalf("expected a conflict error, got %v", err)
+		}
+
+		if len(pushed) != 0 || !slices.Equal(conflict.Paths, []string{"c"}) {
+			t.Fatalf("unexpected conflict report, pushed=%q paths=%q", pushed, conflict.Paths)
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		server := rebaseServer(t, "unrelated")
+		client := &Client{httpC: server.Client(), owner: "owner", repo: "repo", branch: "branch", baseURL: server.URL, graphqlURL: server.URL + "/graphql"}
+
+		_, err := client.PushChanges(context.Background(), "base", changes...)
+		if !errors.Is(err, ErrHeadMoved) {
+			t.Fatalf("expected ErrHeadMoved, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// errLanded is returned by a verify function passed to [Client.do] when a previous attempt of a
+// non-idempotent request turns out to have succeeded, so it must not be retried.
+var errLanded = errors.New("request already applied")
+
+// retryPolicy controls how requests that fail with transient errors are retried
+type retryPolicy struct {
+	// attempts is the total number of attempts, including the first. Zero or one disables retries.
+	attempts int
+
+	// base is the delay before the first retry, doubled for each subsequent retry up to max
+	base time.Duration
+	max  time.Duration
+
+	// maxWait is the longest we'll wait when the server tells us how long to back off for
+	maxWait time.Duration
+}
+
+var defaultRetryPolicy = retryPolicy{
+	attempts: 5,
+	base:     time.Second,
+	max:      30 * time.Second,
+	maxWait:  5 * time.Minute,
+}
+
+// delay returns how long to wait before retrying after the given attempt failed with resp or err,
+// and whether the request should be retried at all.
+func (p retryPolicy) delay(attempt int, resp *http.Response, err error, now time.Time) (time.Duration, bool) {
+	if attempt >= p.attempts {
+		return 0, false
+	}
+
+	if err != nil {
+		// Don't retry if we gave up waiting
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			return 0, false
+		}
+		return p.backoff(attempt), true
+	}
+
+	switch resp.StatusCode {
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return p.backoff(attempt), true
+	case http.StatusTooManyRequests, http.StatusForbidden:
+		wait, limited := rateLimitWait(resp, now)
+		if !limited && resp.StatusCode == http.StatusForbidden {
+			// a 403 without rate limit headers is a permissions problem, retrying won't help
+			return 0, false
+		}
+
+		if wait > p.maxWait {
+			return 0, false
+		}
+
+		return max(wait, p.backoff(attempt)), true
+	}
+
+	return 0, false
+}
+
+// backoff returns an exponentially increasing delay with jitter
+func (p retryPolicy) backoff(attempt int) time.Duration {
+	d := p.base << (attempt - 1)
+	if d > p.max || d <= 0 {
+		d = p.max
+	}
+
+	// Jitter between half and the full delay so concurrent jobs don't retry in lockstep
+	return d/2 + rand.N(d/2+1)
+}
+
+// rateLimitWait inspects the rate limiting headers on a response, returning how long GitHub asked
+// us to wait and whether the response was rate limited at all.
+func rateLimitWait(resp *http.Response, now time.Time) (time.Duration, bool) {
+	// Secondary rate limits set Retry-After, in seconds
+	if v := resp.Header.Get("Retry-After"); v != "" {
+		if secs, err := strconv.Atoi(v); err == nil {
+			return time.Duration(secs) * time.Second, true
+		}
+		if t, err := http.ParseTime(v); err == nil {
+			return t.Sub(now), true
+		}
+	}
+
+	// Primary rate limits report when the limit resets, as epoch seconds
+	if resp.Header.Get("X-RateLimit-Remaining") == "0" {
+		if reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+			return max(time.Unix(reset, 0).Sub(now), 0), true
+		}
+		return 0, true
+	}
+
+	return 0, false
+}
+
+// do sends req, retrying transport errors, server errors and rate limited responses according to
+// the client's retry policy.
+// Requests that aren't safe to repeat should pass a verify function, which is called before every
+// retry to check whether the previous attempt was applied anyway. If verify returns an error (such
+// as [errLanded]) the request is not retried and that error is returned.
+func (c *Client) do(req *http.Request, verify func() error) (*http.Response, error) {
+	for attempt := 1; ; attempt++ {
+		resp, err := c.httpC.Do(req)
+
+		wait, retry := c.retry.delay(attempt, resp, err, time.Now())
+		if !retry {
+			return resp, err
+		}
+
+		reason := ""
+		if err != nil {
+			reason = err.Error()
+		} else {
+			reason = fmt.Sprintf("http %d", resp.StatusCode)
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		log("Request %s %s failed (%s), retrying in %s.\n", req.Method, req.URL.Path, reason, wait.Round(time.Millisecond))
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+
+		if verify != nil {
+			if err := verify(); err != nil {
+				return nil, err
+			}
+		}
+
+		if req.GetBody != nil {
+			req.Body, err = req.GetBody()
+			if err != nil {
+				return nil, fmt.Errorf("rewind request body: %w", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+var testRetryPolicy = retryPolicy{attempts: 3, base: time.Millisecond, max: 5 * time.Millisecond, maxWait: time.Second}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+
+	response := func(status int, headers ...string) *http.Response {
+		resp := &http.Response{StatusCode: status, Header: http.Header{}}
+		for i := 0; i < len(headers); i += 2 {
+			resp.Header.Set(headers[i], headers[i+1])
+		}
+		return resp
+	}
+
+	testcases := []struct {
+		name    string
+		attempt int
+		resp    *http.Response
+		err     error
+
+		retry bool
+		wait  time.Duration
+	}{{
+		name: "success", attempt: 1, resp: response(http.StatusOK),
+	}, {
+		name: "not found", attempt: 1, resp: response(http.StatusNotFound),
+	}, {
+		name: "bad gateway", attempt: 1, resp: response(http.StatusBadGateway),
+		retry: true,
+	}, {
+		name: "out of attempts", attempt: 3, resp: response(http.StatusBadGateway),
+	}, {
+		name: "transport error", attempt: 1, err: io.ErrUnexpectedEOF,
+		retry: true,
+	}, {
+		name: "canceled", attempt: 1, err: context.Canceled,
+	}, {
+		name: "forbidden", attempt: 1, resp: response(http.StatusForbidden),
+	}, {
+		name: "secondary rate limit", attempt: 1, resp: response(http.StatusForbidden, "Retry-After", "1"),
+		retry: true, wait: time.Second,
+	}, {
+		name: "primary rate limit", attempt: 1,
+		resp:  response(http.StatusForbidden, "X-RateLimit-Remaining", "0", "X-RateLimit-Reset", strconv.FormatInt(now.Unix()+1, 10)),
+		retry: true, wait: time.Second,
+	}, {
+		name: "rate limit too long", attempt: 1,
+		resp: response(http.StatusTooManyRequests, "Retry-After", "3600"),
+	}, {
+		name: "too many requests", attempt: 1, resp: response(http.StatusTooManyRequests),
+		retry: true,
+	}}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			wait, retry := testRetryPolicy.delay(tc.attempt, tc.resp, tc.err, now)
+			if retry != tc.retry {
+				t.Fatalf("wrong retry decision, got=%t, want=%t", retry, tc.retry)
+			}
+
+			if tc.wait != 0 && wait != tc.wait {
+				t.Fatalf("wrong wait, got=%s, want=%s", wait, tc.wait)
+			}
+
+			if retry && wait > max(tc.wait, testRetryPolicy.max) {
+				t.Fatalf("wait %s exceeds the maximum", wait)
+			}
+		})
+	}
+}
+
+func TestGetHeadCommitHashRetry(t *testing.T) {
+	logwriter = io.Discard
+
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		fmt.Fprint(w, `{"commit": {"sha": "head"}}`)
+	}))
+	defer server.Close()
+
+	client := &Client{httpC: server.Client(), owner: "owner", repo: "repo", branch: "branch", baseURL: server.URL, retry: testRetryPolicy}
+
+	head, err := client.GetHeadCommitHash(context.Background())
+	requireNoError(t, err)
+
+	if head != "head" || calls != 3 {
+		t.Fatalf("expected head after 3 calls, got head=%s calls=%d", head, calls)
+	}
+}
+
+func TestPushChangeRetryLanded(t *testing.T) {
+	logwriter = io.Discard
+
+	change := Change{hash: "1234", message: "headline\n\nbody", entries: map[string][]byte{"file": []byte("x")}}
+
+	head := "base"
+	mutations := 0
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /repos/owner/repo/branches/branch", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"commit": {"sha": %q}}`, head)
+	})
+	mux.HandleFunc("GET /repos/owner/repo/git/commits/landed", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"sha":     "landed",
+			"message": "headline\n\nbody",
+			"parents": []map[string]string{{"sha": "base"}},
+		})
+	})
+	mux.HandleFunc("POST /graphql", func(w http.ResponseWriter, r *http.Request) {
+		// The commit is created, but the response is lost
+		mutations++
+		head = "landed"
+		w.WriteHeader(http.StatusBadGateway)
+	})
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	client := &Client{httpC: server.Client(), owner: "owner", repo: "repo", branch: "branch", baseURL: server.URL, graphqlURL: server.URL + "/graphql", retry: testRetryPolicy}
+
+	oid, err := client.PushChange(context.Background(), "base", change)
+	requireNoError(t, err)
+
+	if oid != "landed" {
+		t.Errorf("expected the landed commit, got %s", oid)
+	}
+
+	if mutations != 1 {
+		t.Errorf("expected a single mutation, got %d", mutations)
+	}
+}
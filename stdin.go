@@ -64,7 +64,8 @@ func commitsFromStdin(r io.Reader) ([]string, error) {
 	}
 
 	// reverse the commits since log output is newest first
-	// TODO: Should this be detected by commit time instead?
+	// This is only a best guess, the final order is determined from the commit parents when the
+	// changes are read, see [orderChanges].
 	slices.Reverse(commits)
 	return commits, nil
 }
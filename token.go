@@ -1,5 +1,25 @@
 package main
 
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
 type envGetter func(string) string
 
 func getToken(getter envGetter) string {
@@ -12,3 +32,168 @@ func getToken(getter envGetter) string {
 
 	return ""
 }
+
+// flags used to authenticate as a GitHub App installation instead of with a static token
+type appFlags struct {
+	AppID             int64  `name:"app-id" env:"HEADLESS_APP_ID" help:"Authenticate as the GitHub App with this ID instead of using a token."`
+	AppInstallationID int64  `name:"app-installation-id" env:"HEADLESS_APP_INSTALLATION_ID" help:"Installation ID of the GitHub App on the target repository."`
+	AppPrivateKey     string `name:"app-private-key" env:"HEADLESS_APP_PRIVATE_KEY" type:"path" help:"Path to the PEM encoded private key of the GitHub App."`
+}
+
+// tokenSource returns an oauth2.TokenSource for talking to the GitHub API at apiURL.
+// If an app ID is configured, tokens are installation tokens minted for the GitHub App and are
+// refreshed before they expire. Otherwise, a static token is read from the environment.
+func (f appFlags) tokenSource(ctx context.Context, getter envGetter, apiURL string) (oauth2.TokenSource, error) {
+	if f.AppID == 0 {
+		token := getToken(getter)
+		if token == "" {
+			return nil, errors.New("no GitHub token supplied")
+		}
+
+		return oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token}), nil
+	}
+
+	if f.AppInstallationID == 0 {
+		return nil, errors.New("--app-installation-id is required when using --app-id")
+	}
+
+	if f.AppPrivateKey == "" {
+		return nil, errors.New("--app-private-key is required when using --app-id")
+	}
+
+	pemBytes, err := os.ReadFile(f.AppPrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("read app private key: %w", err)
+	}
+
+	key, err := parsePrivateKey(pemBytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse app private key: %w", err)
+	}
+
+	src := &appTokenSource{
+		ctx:            ctx,
+		httpC:          http.DefaultClient,
+		baseURL:        apiURL,
+		appID:          f.AppID,
+		installationID: f.AppInstallationID,
+		key:            key,
+	}
+
+	// Installation tokens are valid for an hour, refresh them a little early so a request started
+	// just before expiry doesn't fail
+	return oauth2.ReuseTokenSourceWithExpiry(nil, src, 5*time.Minute), nil
+}
+
+// parsePrivateKey decodes an RSA private key in either PKCS#1 (as downloaded from GitHub) or
+// PKCS#8 form.
+func parsePrivateKey(data []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("private key is not an RSA key")
+	}
+
+	return rsaKey, nil
+}
+
+// appTokenSource exchanges a GitHub App JWT for an installation access token.
+type appTokenSource struct {
+	ctx            context.Context
+	httpC          *http.Client
+	baseURL        string
+	appID          int64
+	installationID int64
+	key            *rsa.PrivateKey
+}
+
+// jwt returns a signed JSON Web Token identifying the app, valid for a few minutes
+func (s *appTokenSource) jwt() (string, error) {
+	now := time.Now()
+
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+
+	// GitHub recommends backdating iat to allow for clock drift, and rejects tokens valid for more
+	// than ten minutes
+	claims, err := json.Marshal(map[string]any{
+		"iat": now.Add(-time.Minute).Unix(),
+		"exp": now.Add(9 * time.Minute).Unix(),
+		"iss": strconv.FormatInt(s.appID, 10),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	enc := base64.RawURLEncoding
+	unsigned := enc.EncodeToString(header) + "." + enc.EncodeToString(claims)
+
+	digest := sha256.Sum256([]byte(unsigned))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("sign jwt: %w", err)
+	}
+
+	return unsigned + "." + enc.EncodeToString(sig), nil
+}
+
+func (s *appTokenSource) accessTokensURL() string {
+	return fmt.Sprintf("%s/app/installations/%d/access_tokens", s.baseURL, s.installationID)
+}
+
+// Token implements oauth2.TokenSource
+func (s *appTokenSource) Token() (*oauth2.Token, error) {
+	jwt, err := s.jwt()
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(s.ctx, http.MethodPost, s.accessTokensURL(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("prepare http request: %w", err)
+	}
+
+	req.Header.Set("Authorization", "Bearer "+jwt)
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	resp, err := s.httpC.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("create installation token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		return nil, fmt.Errorf("create installation token: http %d", resp.StatusCode)
+	}
+
+	payload := struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}{}
+
+	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+		return nil, fmt.Errorf("decode installation token response: %w", err)
+	}
+
+	log("Minted installation token for app %d, expires at %s\n", s.appID, payload.ExpiresAt.Format(time.RFC3339))
+
+	return &oauth2.Token{
+		AccessToken: payload.Token,
+		Expiry:      payload.ExpiresAt,
+	}, nil
+}
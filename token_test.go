@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGetToken(t *testing.T) {
+	env := map[string]string{"GITHUB_TOKEN": "gh", "GH_TOKEN": "cli"}
+	if got := getToken(func(k string) string { return env[k] }); got != "gh" {
+		t.Fatalf("expected GITHUB_TOKEN to be preferred, got %q", got)
+	}
+
+	env["HEADLESS_TOKEN"] = "headless"
+	if got := getToken(func(k string) string { return env[k] }); got != "headless" {
+		t.Fatalf("expected HEADLESS_TOKEN to be preferred, got %q", got)
+	}
+}
+
+func TestAppTokenSource(t *testing.T) {
+	logwriter = io.Discard
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	requireNoError(t, err)
+
+	keyPath := filepath.Join(t.TempDir(), "app.pem")
+	pemBytes := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	requireNoError(t, os.WriteFile(keyPath, pemBytes, 0o600))
+
+	minted := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/app/installations/99/access_tokens" || r.Method != http.MethodPost {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		jwt, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			t.Error("expected bearer authorization")
+		}
+		verifyJWT(t, &key.PublicKey, jwt)
+
+		minted++
+
+		// The first token is already close to expiry, so the next request should mint a new one
+		expires := time.Now().Add(time.Minute)
+		if minted > 1 {
+			expires = time.Now().Add(time.Hour)
+		}
+
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, `{"token": "token-%d", "expires_at": %q}`, minted, expires.Format(time.RFC3339))
+	}))
+	defer server.Close()
+
+	flags := appFlags{AppID: 42, AppInstallationID: 99, AppPrivateKey: keyPath}
+
+	src, err := flags.tokenSource(context.Background(), func(string) string { return "" }, server.URL)
+	requireNoError(t, err)
+
+	for _, want := range []string{"token-1", "token-2", "token-2"} {
+		tok, err := src.Token()
+		requireNoError(t, err)
+
+		if tok.AccessToken != want {
+			t.Fatalf("expected access token %q, got %q", want, tok.AccessToken)
+		}
+	}
+}
+
+func TestAppTokenSourceFlags(t *testing.T) {
+	getter := func(string) string { return "" }
+
+	if _, err := (appFlags{}).tokenSource(context.Background(), getter, ""); err == nil {
+		t.Error("expected an error without a token or app configuration")
+	}
+
+	if _, err := (appFlags{AppID: 1}).tokenSource(context.Background(), getter, ""); err == nil {
+		t.Error("expected an error without an installation id")
+	}
+
+	if _, err := (appFlags{AppID: 1, AppInstallationID: 2}).tokenSource(context.Background(), getter, ""); err == nil {
+		t.Error("expected an error without a private key")
+	}
+}
+
+func verifyJWT(t *testing.T, pub *rsa.PublicKey, jwt string) {
+	t.Helper()
+
+	parts := strings.Split(jwt, ".")
+	if len(parts) != 3 {
+		t.Fatalf("expected 3 jwt segments, got %d", len(parts))
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	requireNoError(t, err)
+
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	requireNoError(t, rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig), "verify jwt signature")
+
+	rawClaims, err := base64.RawURLEncoding.DecodeString(parts[1])
+	requireNoError(t, err)
+
+	claims := struct {
+		IssuedAt  int64  `json:"iat"`
+		ExpiresAt int64  `json:"exp"`
+		Issuer    string `json:"iss"`
+	}{}
+	requireNoError(t, json.Unmarshal(rawClaims, &claims))
+
+	if claims.Issuer != "42" {
+		t.Errorf("expected issuer to be the app id, got %q", claims.Issuer)
+	}
+
+	if lifetime := time.Duration(claims.ExpiresAt-claims.IssuedAt) * time.Second; lifetime > 10*time.Minute {
+		t.Errorf("jwt lifetime %s exceeds the ten minute maximum", lifetime)
+	}
+}
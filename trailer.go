@@ -0,0 +1,132 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// trailerRegex matches a trailer line, a token of letters, digits and hyphens followed by a colon
+// and the value. Like git, whitespace is allowed before the colon.
+var trailerRegex = regexp.MustCompile(`^([A-Za-z0-9-]+)[ \t]*:[ \t]*(.*)$`)
+
+// gitTrailerPrefixes are prefixes of lines created by git itself, which allow a paragraph that is
+// mostly, but not entirely, trailers to be treated as the trailer block
+var gitTrailerPrefixes = []string{"Signed-off-by: ", "(cherry picked from commit "}
+
+// parseTrailerLine returns the key and value of a trailer line
+func parseTrailerLine(ln string) (key, value string, ok bool) {
+	m := trailerRegex.FindStringSubmatch(ln)
+	if m == nil {
+		return "", "", false
+	}
+
+	return m[1], strings.TrimSpace(m[2]), true
+}
+
+// isTrailerBlock reports whether the lines of a paragraph are a trailer block, following the rules
+// of git interpret-trailers: either every line is a trailer or the continuation of one, or a line
+// was created by git and at least a quarter of the lines are trailers.
+func isTrailerBlock(lines []string) bool {
+	trailers, others := 0, 0
+	recognized, inTrailer := false, false
+
+	for _, ln := range lines {
+		for _, prefix := range gitTrailerPrefixes {
+			if strings.HasPrefix(ln, prefix) {
+				recognized = true
+			}
+		}
+
+		switch {
+		case ln != "" && (ln[0] == ' ' || ln[0] == '\t'):
+			// Continuation lines belong to the line before
+			if inTrailer {
+				trailers++
+			} else {
+				others++
+			}
+		case trailerRegex.MatchString(ln):
+			trailers++
+			inTrailer = true
+		default:
+			others++
+			inTrailer = false
+		}
+	}
+
+	if trailers == 0 {
+		return false
+	}
+
+	return others == 0 || (recognized && trailers*3 >= others)
+}
+
+// splitTrailers splits body at its final paragraph if that paragraph is a trailer block, returning
+// the text before it and the lines of the block. Without a trailer block, body is returned as is.
+func splitTrailers(body string) (string, []string) {
+	body = strings.TrimSpace(body)
+	if body == "" {
+		return "", nil
+	}
+
+	lines := strings.Split(body, "\n")
+	start := 0
+	for i, ln := range lines {
+		if strings.TrimSpace(ln) == "" {
+			start = i + 1
+		}
+	}
+
+	if !isTrailerBlock(lines[start:]) {
+		return body, nil
+	}
+
+	return strings.TrimSpace(strings.Join(lines[:start], "\n")), lines[start:]
+}
+
+// normalizeTrailer returns ln with the casing of well known keys fixed, since GitHub only credits
+// co-authors from a "Co-authored-by" trailer spelled exactly so
+func normalizeTrailer(ln string) string {
+	key, value, ok := parseTrailerLine(ln)
+	if ok && strings.EqualFold(key, "Co-authored-by") {
+		return "Co-authored-by: " + value
+	}
+
+	return ln
+}
+
+// mergeTrailers adds trailers to the end of block, the lines of a trailer block, skipping any
+// whose key and value (compared case insensitively, like git) are already there. The lines of block
+// are kept, with only their casing normalized.
+func mergeTrailers(block []string, trailers []string) []string {
+	merged := []string{}
+	seen := map[[2]string]bool{}
+
+	// record records a trailer line, and reports whether it was already recorded
+	record := func(ln string) bool {
+		key, value, ok := parseTrailerLine(ln)
+		if !ok {
+			return false
+		}
+
+		k := [2]string{strings.ToLower(key), strings.ToLower(value)}
+		dup := seen[k]
+		seen[k] = true
+		return dup
+	}
+
+	for _, ln := range block {
+		ln = normalizeTrailer(ln)
+		record(ln)
+		merged = append(merged, ln)
+	}
+
+	for _, ln := range trailers {
+		ln = normalizeTrailer(ln)
+		if !record(ln) {
+			merged = append(merged, ln)
+		}
+	}
+
+	return merged
+}
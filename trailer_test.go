@@ -0,0 +1,73 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSplitTrailers(t *testing.T) {
+	testcases := []struct {
+		name  string
+		body  string
+		text  string
+		block []string
+	}{
+		{"empty", "", "", nil},
+		{"no trailers", "some text\n\nmore text", "some text\n\nmore text", nil},
+		{"only trailers", "Foo: bar\nBaz: qux", "", []string{"Foo: bar", "Baz: qux"}},
+		{"final paragraph", "text\n\nFoo: bar", "text", []string{"Foo: bar"}},
+		{"not the final paragraph", "Foo: bar\n\ntext", "Foo: bar\n\ntext", nil},
+		{"whitespace before colon", "text\n\nFoo : bar", "text", []string{"Foo : bar"}},
+		{"continuation", "text\n\nFoo: bar\n  continued\nBaz: qux", "text", []string{"Foo: bar", "  continued", "Baz: qux"}},
+		{"mixed paragraph", "text\n\nFoo: bar\nnot a trailer", "text\n\nFoo: bar\nnot a trailer", nil},
+		{"whitespace separated paragraph", "text\n  \nFoo: bar", "text", []string{"Foo: bar"}},
+		{
+			"created by git",
+			"text\n\n(cherry picked from commit abc)\nSigned-off-by: someone\nnot a trailer",
+			"text",
+			[]string{"(cherry picked from commit abc)", "Signed-off-by: someone", "not a trailer"},
+		},
+		{
+			"created by git but mostly text",
+			"text\n\nSigned-off-by: someone\none\ntwo\nthree\nfour",
+			"text\n\nSigned-off-by: someone\none\ntwo\nthree\nfour",
+			nil,
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			text, block := splitTrailers(tc.body)
+			if text != tc.text {
+				t.Errorf("wrong text, got=%q, want=%q", text, tc.text)
+			}
+			if !slices.Equal(block, tc.block) {
+				t.Errorf("wrong block, got=%q, want=%q", block, tc.block)
+			}
+		})
+	}
+}
+
+func TestMergeTrailers(t *testing.T) {
+	block := []string{"CO-AUTHORED-BY: Jane <jane@example.com>", "Foo: bar", "  continued"}
+	trailers := []string{
+		"Co-authored-by: jane <JANE@example.com>",
+		"co-authored-by: John <john@example.com>",
+		"Foo: baz",
+		"Foo: baz",
+	}
+
+	got := strings.Join(mergeTrailers(block, trailers), "\n")
+	want := strings.Join([]string{
+		"Co-authored-by: Jane <jane@example.com>",
+		"Foo: bar",
+		"  continued",
+		"Co-authored-by: John <john@example.com>",
+		"Foo: baz",
+	}, "\n")
+
+	if got != want {
+		t.Fatalf("wrong trailers, got=%q, want=%q", got, want)
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+)
+
+var errTreeTruncated = errors.New("too many files to list")
+
+// remoteFile is a file in a tree on the remote
+type remoteFile struct {
+	Mode string
+	Sha  string
+}
+
+// GetTree returns every file in the tree of commit on the remote, by path
+func (c *Client) GetTree(ctx context.Context, commit string) (map[string]remoteFile, error) {
+	payload := struct {
+		Truncated bool
+		Tree      []struct {
+			Path string
+			Mode string
+			Type string
+			Sha  string
+		}
+	}{}
+
+	if _, err := c.doJSON(ctx, http.MethodGet, c.gitDataURL("trees/"+commit+"?recursive=1"), nil, http.StatusOK, &payload); err != nil {
+		return nil, fmt.Errorf("get tree %s: %w", commit, err)
+	}
+
+	// GitHub stops listing recursive trees after 100,000 entries
+	if payload.Truncated {
+		return nil, fmt.Errorf("get tree %s: %w", commit, errTreeTruncated)
+	}
+
+	files := map[string]remoteFile{}
+	for _, e := range payload.Tree {
+		if e.Type != "tree" {
+			files[e.Path] = remoteFile{Mode: e.Mode, Sha: e.Sha}
+		}
+	}
+
+	return files, nil
+}
+
+// mirrorDir is a directory that should hold exactly the files in a change after it is pushed, see
+// [expandMirrors]
+type mirrorDir struct {
+	dir string
+
+	// exclude lists patterns for files to leave alone, see [excluded]. They are relative to the
+	// local repository, so they are matched against local paths, see [mirrorDir.excludes].
+	exclude []string
+
+	// local is the local directory dir was read from, if dir was moved by --prefix or
+	// --strip-prefix, see [relocateChange]
+	local string
+}
+
+// contains reports whether p is inside the mirrored directory
+func (m mirrorDir) contains(p string) bool {
+	return m.dir == "." || strings.HasPrefix(p, m.dir+"/")
+}
+
+// excludes reports whether p, a remote path inside the mirror, matches one of the exclude patterns
+// once mapped back to the local path it corresponds to
+func (m mirrorDir) excludes(p string) bool {
+	rel := p
+	if m.dir != "." {
+		rel = strings.TrimPrefix(p, m.dir+"/")
+	}
+
+	return excluded(m.exclude, path.Join(cmp.Or(m.local, m.dir), rel))
+}
+
+// expandMirrors adds a deletion to change for every file in tree that is inside one of the
+// change's mirrored directories, but is not part of the change or excluded. It returns the number
+// of deletions added.
+func expandMirrors(change Change, tree map[string]remoteFile) int {
+	added := 0
+	for p := range tree {
+		if _, ok := change.entries[p]; ok {
+			continue
+		}
+
+		for _, m := range change.mirrors {
+			if m.contains(p) && !m.excludes(p) {
+				change.entries[p] = nil
+				added++
+				break
+			}
+		}
+	}
+
+	return added
+}
+
+// blobSha returns the hash git gives a blob with content
+func blobSha(content []byte) string {
+	h := sha1.New()
+	fmt.Fprintf(h, "blob %d\x00", len(content))
+	h.Write(content)
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+// remoteFileFor returns the tree entry the remote would have for p after change is pushed, which
+// must not be a deletion
+func remoteFileFor(change Change, p string) remoteFile {
+	mode := change.mode(p)
+	if mode == modeSubmodule {
+		return remoteFile{Mode: mode, Sha: string(change.entries[p])}
+	}
+	return remoteFile{Mode: mode, Sha: blobSha(change.entries[p])}
+}
+
+// dropUnchanged removes the files from changes that already have the same content and mode in tree,
+// and then drops any changes left without files. Changes that had no files to begin with, such as
+// commits made with --allow-empty, are kept. Each change is applied to tree in turn, so later
+// changes are compared with the files as they will be once the earlier changes are pushed. Returns
+// the changes left, and the sorted paths that were left out.
+func dropUnchanged(changes []Change, tree map[string]remoteFile) ([]Change, []string) {
+	out := []Change{}
+	dropped := []string{}
+	for _, c := range changes {
+		kept := map[string][]byte{}
+		for _, p := range slices.Sorted(maps.Keys(c.entries)) {
+			content := c.entries[p]
+			if content == nil {
+				kept[p] = nil
+				delete(tree, p)
+				continue
+			}
+
+			f := remoteFileFor(c, p)
+			if existing, ok := tree[p]; ok && existing == f {
+				if !slices.Contains(dropped, p) {
+					dropped = append(dropped, p)
+				}
+				continue
+			}
+
+			kept[p] = content
+			tree[p] = f
+		}
+
+		if len(kept) == 0 && len(c.entries) > 0 {
+			log("Commit %s doesn't change any files on the remote, skipping it.\n", c.hash)
+			continue
+		} else if skipped := len(c.entries) - len(kept); skipped > 0 {
+			log("Commit %s: skipping %d files that already match the remote.\n", c.hash, skipped)
+		}
+
+		c.entries = kept
+		out = append(out, c)
+	}
+
+	slices.Sort(dropped)
+	return out, dropped
+}
+
+// MissingDeletionsError is returned when changes delete files that don't exist on the remote
+type MissingDeletionsError struct {
+	Head  string
+	Paths []string
+}
+
+func (e *MissingDeletionsError) Error() string {
+	return fmt.Sprintf("cannot delete files that don't exist on the remote at %s: %s (see --ignore-missing-deletions)",
+		e.Head, strings.Join(e.Paths, ", "))
+}
+
+// checkDeletions looks for deletions in changes of files that don't exist in tree, the remote tree
+// at head. Each change is applied to tree in turn, so later changes may delete files added by
+// earlier ones. Missing deletions are an error listing all of them, unless ignore is set, in which
+// case they are removed from the changes, along with any changes left without files.
+func checkDeletions(changes []Change, tree map[string]remoteFile, head string, ignore bool) ([]Change, error) {
+	missing := []string{}
+	out := []Change{}
+	for _, c := range changes {
+		kept := map[string][]byte{}
+		for _, p := range slices.Sorted(maps.Keys(c.entries)) {
+			content := c.entries[p]
+			if content != nil {
+				kept[p] = content
+				tree[p] = remoteFileFor(c, p)
+				continue
+			}
+
+			if _, ok := tree[p]; !ok {
+				if !slices.Contains(missing, p) {
+					missing = append(missing, p)
+				}
+				continue
+			}
+
+			kept[p] = nil
+			delete(tree, p)
+		}
+
+		if len(kept) == 0 && len(c.entries) > 0 {
+			log("Commit %s only deletes files that don't exist on the remote, skipping it.\n", c.hash)
+			continue
+		}
+
+		c.entries = kept
+		out = append(out, c)
+	}
+
+	if len(missing) == 0 {
+		return changes, nil
+	}
+
+	if !ignore {
+		slices.Sort(missing)
+		return nil, &MissingDeletionsError{Head: head, Paths: missing}
+	}
+
+	log("Ignoring %d deletions of files that don't exist on the remote.\n", len(missing))
+	return out, nil
+}
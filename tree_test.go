@@ -0,0 +1,144 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestExpandMirrors(t *testing.T) {
+	change := Change{
+		entries: map[string][]byte{
+			"dist/app.js": []byte("app"),
+			"README":      []byte("readme"),
+		},
+		mirrors: []mirrorDir{{dir: "dist", exclude: []string{"*.keep"}}},
+	}
+
+	tree := map[string]remoteFile{
+		"README":           {},
+		"dist/app.js":      {},
+		"dist/old.js":      {},
+		"dist/deep/old.js": {},
+		"dist/.keep":       {},
+		"distribution":     {},
+		"other/file":       {},
+	}
+
+	if n := expandMirrors(change, tree); n != 2 {
+		t.Errorf("expected 2 deletions, got %d", n)
+	}
+
+	want := []string{"README", "dist/app.js", "dist/deep/old.js", "dist/old.js"}
+	if got := slices.Sorted(maps.Keys(change.entries)); !slices.Equal(got, want) {
+		t.Fatalf("expected entries %q, got %q", want, got)
+	}
+
+	if change.entries["dist/old.js"] != nil || change.entries["dist/app.js"] == nil {
+		t.Error("expected only the missing files to be deleted")
+	}
+}
+
+func TestBlobSha(t *testing.T) {
+	// git hash-object --stdin <<< "hello"
+	if got := blobSha([]byte("hello\n")); got != "ce013625030ba8dba906f756967f9e9ca394464a" {
+		t.Errorf("unexpected blob sha %s", got)
+	}
+
+	// The empty blob
+	if got := blobSha([]byte{}); got != "e69de29bb2d1d6434b8b29ae775ad8c2e48c5391" {
+		t.Errorf("unexpected blob sha %s", got)
+	}
+}
+
+func TestDropUnchanged(t *testing.T) {
+	logwriter = io.Discard
+
+	sub := strings.Repeat("1", 40)
+	tree := map[string]remoteFile{
+		"same":   {Mode: modeRegular, Sha: blobSha([]byte("same"))},
+		"script": {Mode: modeRegular, Sha: blobSha([]byte("#!/bin/sh"))},
+		"sub":    {Mode: modeSubmodule, Sha: sub},
+		"gone":   {Mode: modeRegular, Sha: blobSha([]byte("gone"))},
+	}
+
+	changes := []Change{
+		{
+			hash: "first",
+			entries: map[string][]byte{
+				"same":   []byte("same"),
+				"script": []byte("#!/bin/sh"),
+				"sub":    []byte(sub),
+				"new":    []byte("new"),
+				"gone":   nil,
+			},
+			modes: map[string]string{"script": modeExecutable, "sub": modeSubmodule},
+		},
+		// Matches the remote as it will be after the first change
+		{hash: "second", entries: map[string][]byte{"new": []byte("new")}},
+		{hash: "third", entries: map[string][]byte{"new": []byte("newer"), "same": []byte("same")}},
+		// Intentionally empty
+		{hash: "fourth", entries: map[string][]byte{}},
+	}
+
+	got, dropped := dropUnchanged(changes, tree)
+	if want := []string{"new", "same", "sub"}; !slices.Equal(dropped, want) {
+		t.Errorf("expected %q to be left out, got %q", want, dropped)
+	}
+
+	if len(got) != 3 || got[0].hash != "first" || got[1].hash != "third" || got[2].hash != "fourth" {
+		t.Fatalf("expected only the second change to be dropped, got %+v", got)
+	}
+
+	// Same content with a different mode is still a change
+	if want := []string{"gone", "new", "script"}; !slices.Equal(slices.Sorted(maps.Keys(got[0].entries)), want) {
+		t.Errorf("expected first to change %q, got %q", want, slices.Sorted(maps.Keys(got[0].entries)))
+	}
+
+	if want := []string{"new"}; !slices.Equal(slices.Sorted(maps.Keys(got[1].entries)), want) {
+		t.Errorf("expected third to change %q, got %q", want, slices.Sorted(maps.Keys(got[1].entries)))
+	}
+}
+
+func TestCheckDeletions(t *testing.T) {
+	logwriter = io.Discard
+
+	tree := map[string]remoteFile{"exists": {}, "also-exists": {}}
+
+	changes := []Change{
+		{hash: "first", entries: map[string][]byte{"exists": nil, "missing": nil, "added": []byte("added")}},
+		// Deleting files added or removed by the first change
+		{hash: "second", entries: map[string][]byte{"added": nil, "exists": nil}},
+		{hash: "third", entries: map[string][]byte{"another-missing": nil}},
+		{hash: "fourth", entries: map[string][]byte{"also-exists": nil}},
+	}
+
+	_, err := checkDeletions(changes, maps.Clone(tree), "head", false)
+
+	var missing *MissingDeletionsError
+	if !errors.As(err, &missing) {
+		t.Fatalf("expected a missing deletions error, got %v", err)
+	}
+
+	if want := []string{"another-missing", "exists", "missing"}; !slices.Equal(missing.Paths, want) {
+		t.Errorf("expected missing paths %q, got %q", want, missing.Paths)
+	}
+
+	got, err := checkDeletions(changes, maps.Clone(tree), "head", true)
+	requireNoError(t, err)
+
+	if len(got) != 3 || got[0].hash != "first" || got[1].hash != "second" || got[2].hash != "fourth" {
+		t.Fatalf("expected the third change to be dropped, got %+v", got)
+	}
+
+	if want := []string{"added", "exists"}; !slices.Equal(slices.Sorted(maps.Keys(got[0].entries)), want) {
+		t.Errorf("expected first to change %q, got %q", want, slices.Sorted(maps.Keys(got[0].entries)))
+	}
+
+	if want := []string{"added"}; !slices.Equal(slices.Sorted(maps.Keys(got[1].entries)), want) {
+		t.Errorf("expected second to change %q, got %q", want, slices.Sorted(maps.Keys(got[1].entries)))
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// emptyTree is the hash of the tree with no entries, which exists in every repository
+const emptyTree = "4b825dc642cb6eb9a060e54bf8d69288fbee4904"
+
+// toplevel returns the root of the worktree containing r.path
+func (r *Repository) toplevel() (string, error) {
+	cmd := exec.Command("git", "rev-parse", "--show-toplevel")
+	cmd.Dir = r.path
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("find worktree root: %w", err)
+	}
+
+	return strings.TrimSpace(string(out)), nil
+}
+
+// stagedChanges returns the contents of every file that differs between the index and HEAD, and the
+// modes of any that are not regular files, in the same form as [readEntries]. Contents are read
+// from the index rather than the worktree. Without a HEAD commit, everything in the index is
+// returned.
+func (r *Repository) stagedChanges() (map[string][]byte, map[string]string, error) {
+	base := "HEAD"
+	cmd := exec.Command("git", "rev-parse", "--verify", "--quiet", "HEAD^{commit}")
+	cmd.Dir = r.path
+	if err := cmd.Run(); err != nil {
+		base = emptyTree
+	}
+
+	cmd = exec.Command("git", "diff-index", "--cached", "--raw", "-z", "--no-renames", base, "--")
+	cmd.Dir = r.path
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, nil, fmt.Errorf("git diff-index: %w", err)
+	}
+
+	diff, err := parseRawEntries(out)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	objects, err := r.objects()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return readEntries(objects, diff)
+}
+
+// worktreeChanges returns the contents of every file that git status reports as changed, whether
+// staged or not, and the modes of any that are not regular files. Untracked files are included
+// unless they are ignored. Contents are read from the worktree, and files that are missing from it
+// are deletions.
+func (r *Repository) worktreeChanges() (map[string][]byte, map[string]string, error) {
+	root, err := r.toplevel()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Porcelain output names paths relative to the root of the worktree
+	cmd := exec.Command("git", "status", "--porcelain", "-z", "--untracked-files=all", "--no-renames")
+	cmd.Dir = r.path
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, nil, fmt.Errorf("git status: %w", err)
+	}
+
+	changes := map[string][]byte{}
+	modes := map[string]string{}
+
+	// Each entry is "XY <path>"
+	for _, entry := range splitNUL(out) {
+		if len(entry) < 4 {
+			return nil, nil, fmt.Errorf("unexpected git status output %q", entry)
+		}
+
+		status, path := entry[:2], entry[3:]
+		if strings.Contains(status, "U") || status == "AA" || status == "DD" {
+			return nil, nil, fmt.Errorf("%s is unmerged", path)
+		}
+
+		content, mode, err := readWorktreeFile(filepath.Join(root, filepath.FromSlash(path)))
+		if err != nil {
+			return nil, nil, fmt.Errorf("read %q: %w", path, err)
+		}
+
+		changes[path] = content
+		if content != nil && mode != modeRegular {
+			modes[path] = mode
+		}
+	}
+
+	return changes, modes, nil
+}
+
+// readWorktreeFile returns the content and git mode of the file at path, or nil content if it
+// doesn't exist. Symlinks are not followed, their content is the link target.
+func readWorktreeFile(path string) ([]byte, string, error) {
+	fi, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil, "", nil
+	} else if err != nil {
+		return nil, "", err
+	}
+
+	switch {
+	case fi.Mode()&fs.ModeSymlink != 0:
+		target, err := os.Readlink(path)
+		if err != nil {
+			return nil, "", err
+		}
+		return []byte(filepath.ToSlash(target)), modeSymlink, nil
+	case fi.IsDir():
+		return nil, "", fmt.Errorf("is a directory, such as a submodule, which is not supported")
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if fi.Mode()&0o111 != 0 {
+		return content, modeExecutable, nil
+	}
+
+	return content, modeRegular, nil
+}
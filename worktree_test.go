@@ -0,0 +1,104 @@
+package main
+
+import (
+	"maps"
+	"os"
+	"testing"
+)
+
+// worktreeTestRepo returns a repository with a committed file, and staged, unstaged, untracked and
+// ignored changes on top
+func worktreeTestRepo(t *testing.T) *testRepository {
+	tr := testRepo(t)
+
+	requireNoError(t, os.WriteFile(tr.path(".gitignore"), []byte("ignored\n"), 0o644))
+	requireNoError(t, os.WriteFile(tr.path("committed"), []byte("committed"), 0o644))
+	requireNoError(t, os.WriteFile(tr.path("removed"), []byte("removed"), 0o644))
+	requireNoError(t, os.WriteFile(tr.path("unstaged"), []byte("unstaged"), 0o644))
+	tr.git("add", "-A")
+	tr.git("commit", "--message", "initial commit")
+
+	// Staged: a modification, a deletion and a new executable
+	requireNoError(t, os.WriteFile(tr.path("committed"), []byte("staged"), 0o644))
+	requireNoError(t, os.WriteFile(tr.path("script.sh"), []byte("#!/bin/sh\n"), 0o755))
+	tr.git("rm", "--quiet", "removed")
+	tr.git("add", "committed", "script.sh")
+
+	// Not staged: a further modification to a staged file, a modification, and new files
+	requireNoError(t, os.WriteFile(tr.path("committed"), []byte("modified again"), 0o644))
+	requireNoError(t, os.WriteFile(tr.path("unstaged"), []byte("changed"), 0o644))
+	requireNoError(t, os.MkdirAll(tr.path("dir"), 0o755))
+	requireNoError(t, os.WriteFile(tr.path("dir", "untracked"), []byte("untracked"), 0o644))
+	requireNoError(t, os.WriteFile(tr.path("ignored"), []byte("ignored"), 0o644))
+
+	return tr
+}
+
+func TestStagedChanges(t *testing.T) {
+	tr := worktreeTestRepo(t)
+
+	r := &Repository{path: tr.root}
+	defer r.Close()
+
+	entries, modes, err := r.stagedChanges()
+	requireNoError(t, err)
+
+	want := map[string][]byte{
+		"committed": []byte("staged"),
+		"script.sh": []byte("#!/bin/sh\n"),
+		"removed":   nil,
+	}
+
+	if !maps.EqualFunc(entries, want, func(a, b []byte) bool { return string(a) == string(b) && (a == nil) == (b == nil) }) {
+		t.Errorf("expected entries %q, got %q", want, entries)
+	}
+
+	if !maps.Equal(modes, map[string]string{"script.sh": modeExecutable}) {
+		t.Errorf("expected script.sh to be executable, got modes %q", modes)
+	}
+}
+
+func TestStagedChangesNoHead(t *testing.T) {
+	tr := testRepo(t)
+	requireNoError(t, os.WriteFile(tr.path("first"), []byte("first"), 0o644))
+	tr.git("add", "first")
+
+	r := &Repository{path: tr.root}
+	defer r.Close()
+
+	entries, _, err := r.stagedChanges()
+	requireNoError(t, err)
+
+	if len(entries) != 1 || string(entries["first"]) != "first" {
+		t.Errorf("expected the staged file, got %q", entries)
+	}
+}
+
+func TestWorktreeChanges(t *testing.T) {
+	tr := worktreeTestRepo(t)
+	requireNoError(t, os.Symlink("unstaged", tr.path("link")))
+
+	// Paths are relative to the root of the worktree, wherever git is run from
+	r := &Repository{path: tr.path("dir")}
+	defer r.Close()
+
+	entries, modes, err := r.worktreeChanges()
+	requireNoError(t, err)
+
+	want := map[string][]byte{
+		"committed":     []byte("modified again"),
+		"script.sh":     []byte("#!/bin/sh\n"),
+		"removed":       nil,
+		"unstaged":      []byte("changed"),
+		"dir/untracked": []byte("untracked"),
+		"link":          []byte("unstaged"),
+	}
+
+	if !maps.EqualFunc(entries, want, func(a, b []byte) bool { return string(a) == string(b) && (a == nil) == (b == nil) }) {
+		t.Errorf("expected entries %q, got %q", want, entries)
+	}
+
+	if !maps.Equal(modes, map[string]string{"script.sh": modeExecutable, "link": modeSymlink}) {
+		t.Errorf("unexpected modes %q", modes)
+	}
+}